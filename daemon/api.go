@@ -0,0 +1,124 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+	"github.com/gzjjjfree/cf-scanner/utils"
+)
+
+// Handler 返回常驻服务的 HTTP API：
+//
+//	GET  /best?n=10&colo=HKG  当前结果池中最优的 n 个 IP（可按数据中心过滤，逗号分隔）
+//	GET  /status              当前阶段、扫描进度和结果池概况
+//	POST /scan                以请求体中的扫描参数（JSON，key 同配置文件）覆盖配置并启动一次扫描
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /best", d.handleBest)
+	mux.HandleFunc("GET /status", d.handleStatus)
+	mux.HandleFunc("POST /scan", d.handleScan)
+	return mux
+}
+
+// serveHTTP 在后台启动 HTTP API，ctx 取消时关闭服务
+func (d *Daemon) serveHTTP(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("HTTP API 监听失败: %v", err)
+	}
+
+	srv := &http.Server{Handler: d.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go srv.Serve(ln)
+
+	fmt.Printf("HTTP API 已启动: http://%s\n", ln.Addr())
+	return nil
+}
+
+func (d *Daemon) handleBest(w http.ResponseWriter, r *http.Request) {
+	pool := d.Best()
+
+	if colos := utils.SplitList(r.URL.Query().Get("colo")); len(colos) > 0 {
+		var filtered []scanner.FinalResult
+		for _, res := range pool {
+			for _, c := range colos {
+				if strings.EqualFold(res.Colo, c) {
+					filtered = append(filtered, res)
+					break
+				}
+			}
+		}
+		pool = filtered
+	}
+
+	if s := r.URL.Query().Get("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("参数 n 必须是非负整数"))
+			return
+		}
+		if n < len(pool) {
+			pool = pool[:n]
+		}
+	}
+
+	// 输出包含全部指标的完整形式
+	details := make([]scanner.ResultDetail, len(pool))
+	for i, res := range pool {
+		details[i] = scanner.ResultDetail(res)
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+func (d *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, d.Status())
+}
+
+func (d *Daemon) handleScan(w http.ResponseWriter, r *http.Request) {
+	overrides := make(map[string]interface{})
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		decoder.UseNumber() // 整数（如 seed）不经过 float64
+		if err := decoder.Decode(&overrides); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("请求体不是合法的 JSON 对象: %v", err))
+			return
+		}
+	}
+
+	err := d.TriggerScan(overrides)
+	switch {
+	case errors.Is(err, ErrBusy):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+	}
+}
+
+// writeJSON 以 JSON 格式输出响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(v)
+}
+
+// writeError 以 {"error": "..."} 格式输出错误
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
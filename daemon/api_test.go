@@ -0,0 +1,219 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+	"github.com/gzjjjfree/cf-scanner/utils"
+)
+
+// testConfig 返回一份能通过校验的配置
+func testConfig() utils.Config {
+	return utils.Config{
+		Domain:       "speed.cloudflare.com/__down?bytes=1000",
+		Scheme:       "https",
+		IPFile:       "ip.txt",
+		WorkerCount:  1,
+		LatencyLimit: 1000,
+		Probe:        "tls",
+		Timeout:      time.Second,
+		Rounds:       1,
+		SortBy:       "latency",
+		HTTPVersion:  "1.1",
+		SpeedSort:    "download",
+		Conns:        1,
+		DeepWorkers:  1,
+		OutCount:     1,
+		TestCount:    1,
+		Refine:       1,
+		BlockTTL:     time.Hour,
+		Interval:     time.Hour,
+		HistoryDays:  1,
+	}
+}
+
+func newTestDaemon(t *testing.T) (*Daemon, *httptest.Server) {
+	t.Helper()
+	conf := testConfig()
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("测试配置无效: %v", err)
+	}
+	d := New(conf)
+	d.pool = []scanner.FinalResult{
+		{IP: "1.1.1.1", Colo: "HKG", DownloadMBs: 90},
+		{IP: "1.1.1.2", Colo: "NRT", DownloadMBs: 80},
+		{IP: "1.1.1.3", Colo: "HKG", DownloadMBs: 70},
+	}
+	srv := httptest.NewServer(d.Handler())
+	t.Cleanup(srv.Close)
+	return d, srv
+}
+
+// getJSON 发送 GET 请求并解析 JSON 响应
+func getJSON(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("解析 %s 的响应失败: %v", url, err)
+	}
+	return resp.StatusCode
+}
+
+func TestBest(t *testing.T) {
+	_, srv := newTestDaemon(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"1.1.1.1", "1.1.1.2", "1.1.1.3"}},
+		{"?n=2", []string{"1.1.1.1", "1.1.1.2"}},
+		{"?n=10", []string{"1.1.1.1", "1.1.1.2", "1.1.1.3"}},
+		{"?n=0", []string{}},
+		{"?colo=hkg", []string{"1.1.1.1", "1.1.1.3"}},
+		{"?colo=NRT,HKG&n=2", []string{"1.1.1.1", "1.1.1.2"}},
+		{"?colo=SJC", []string{}},
+	}
+	for _, tt := range tests {
+		var pool []scanner.ResultDetail
+		if status := getJSON(t, srv.URL+"/best"+tt.query, &pool); status != http.StatusOK {
+			t.Fatalf("/best%s 状态码 = %d", tt.query, status)
+		}
+		got := make([]string, 0, len(pool))
+		for _, r := range pool {
+			got = append(got, r.IP)
+			// 返回全部指标
+			if r.DownloadMBs == 0 || r.Colo == "" {
+				t.Errorf("/best%s 缺少指标: %+v", tt.query, r)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("/best%s = %v，期望 %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestBestInvalidN(t *testing.T) {
+	_, srv := newTestDaemon(t)
+
+	for _, n := range []string{"abc", "-1", "1.5"} {
+		var body map[string]string
+		if status := getJSON(t, srv.URL+"/best?n="+n, &body); status != http.StatusBadRequest {
+			t.Errorf("/best?n=%s 状态码 = %d，期望 400", n, status)
+		}
+		if body["error"] == "" {
+			t.Errorf("/best?n=%s 没有返回错误信息", n)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	d, srv := newTestDaemon(t)
+	d.lastScan = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var status Status
+	if code := getJSON(t, srv.URL+"/status", &status); code != http.StatusOK {
+		t.Fatalf("/status 状态码 = %d", code)
+	}
+	if status.Stage != "idle" || status.PoolSize != 3 || !status.LastScan.Equal(d.lastScan) {
+		t.Errorf("/status = %+v", status)
+	}
+}
+
+// postScan 向 /scan 发送请求体，返回状态码
+func postScan(t *testing.T, srv *httptest.Server, body string) int {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/scan", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v map[string]string
+	json.NewDecoder(resp.Body).Decode(&v)
+	if resp.StatusCode != http.StatusAccepted && v["error"] == "" {
+		t.Errorf("POST /scan %s 没有返回错误信息", body)
+	}
+	return resp.StatusCode
+}
+
+func TestScanRejectsBadRequests(t *testing.T) {
+	_, srv := newTestDaemon(t)
+
+	tests := []string{
+		`not json`,
+		`{"unknown_key": 1}`,
+		`{"workers": 0}`,
+		`{"colo": 1}`,
+		// 文件路径、监听地址等配置项不能通过 HTTP 修改
+		`{"out_file": "/etc/cron.d/x"}`,
+		`{"append_file": "/tmp/x"}`,
+		`{"ip_file": "/etc/passwd"}`,
+		`{"exclude": "/etc/shadow"}`,
+		`{"blocklist": "/tmp/x"}`,
+		`{"template": "/etc/passwd"}`,
+		`{"xray_template": "/etc/passwd"}`,
+		`{"history": "/tmp/x.db"}`,
+		`{"listen": ":80"}`,
+		`{"colo": "HKG", "out_file": "/tmp/x"}`,
+	}
+	for _, body := range tests {
+		if status := postScan(t, srv, body); status != http.StatusBadRequest {
+			t.Errorf("POST /scan %s 状态码 = %d，期望 400", body, status)
+		}
+	}
+}
+
+func TestScanBusy(t *testing.T) {
+	d, srv := newTestDaemon(t)
+
+	// 模拟正在进行的扫描，允许的配置项通过校验后返回 409，不会真正启动扫描
+	d.scanMu.Lock()
+	defer d.scanMu.Unlock()
+
+	for _, body := range []string{
+		``,
+		`{"colo": "HKG", "out_count": 20}`,
+		`{"domain": "example.com/__down", "ports": "443,8443", "test_count": 50, "latency": 300, "rounds": 3}`,
+	} {
+		if status := postScan(t, srv, body); status != http.StatusConflict {
+			t.Errorf("POST /scan %s 状态码 = %d，期望 409", body, status)
+		}
+	}
+	// 不允许的配置项在检查是否空闲之前就被拒绝
+	if status := postScan(t, srv, `{"out_file": "/tmp/x"}`); status != http.StatusBadRequest {
+		t.Errorf("POST /scan 不允许的配置项状态码 = %d，期望 400", status)
+	}
+}
+
+func TestScanSeed(t *testing.T) {
+	d, srv := newTestDaemon(t)
+	// 只有拒绝连接的地址，扫描很快结束且不会写入任何结果文件
+	d.conf.IPFile = filepath.Join(t.TempDir(), "ip.txt")
+	d.conf.Ports = "1"
+	if err := os.WriteFile(d.conf.IPFile, []byte("127.0.0.0/30\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	utils.SetSeed(1)
+	if status := postScan(t, srv, `{"seed": 1760612345678901237}`); status != http.StatusAccepted {
+		t.Fatalf("POST /scan 状态码 = %d，期望 202", status)
+	}
+	// 等待后台扫描结束
+	d.scanMu.Lock()
+	d.scanMu.Unlock()
+
+	if got := utils.Seed(); got != 1760612345678901237 {
+		t.Errorf("扫描使用的种子为 %d，期望 1760612345678901237", got)
+	}
+}
@@ -0,0 +1,350 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gzjjjfree/cf-scanner/history"
+	"github.com/gzjjjfree/cf-scanner/scanner"
+	"github.com/gzjjjfree/cf-scanner/utils"
+)
+
+// ErrBusy 已有完整扫描正在进行
+var ErrBusy = errors.New("已有扫描正在进行")
+
+// scanKeys POST /scan 允许覆盖的配置项，只包含扫描和测速参数；
+// 文件路径、监听地址等配置项不能通过 HTTP 修改，避免读写任意文件
+var scanKeys = map[string]bool{
+	"domain": true, "scheme": true, "ports": true, "best_port": true,
+	"workers": true, "latency": true, "probe": true, "timeout": true,
+	"rounds": true, "max_loss": true, "sort": true, "trace": true,
+	"colo": true, "xcolo": true, "ws_path": true, "ws_host": true,
+	"min_speed": true, "conns": true, "http_version": true, "upload": true,
+	"min_upload": true, "speed_sort": true, "score": true, "deep_workers": true,
+	"link_mbps": true, "out_count": true, "test_count": true, "refine": true,
+	"seed": true, "history_rank": true,
+}
+
+// Daemon 常驻模式：按计划执行完整扫描，在内存中保存最优结果池，
+// 并在两次完整扫描之间定期复查，及时剔除失效的 IP
+type Daemon struct {
+	conf     utils.Config
+	progress *scanner.Progress
+	scanMu   sync.Mutex      // 同一时间只允许一次完整扫描
+	runCtx   context.Context // Run 的上下文，HTTP 触发的扫描也使用它
+	history  *history.Store  // 扫描历史数据库，未配置时为 nil
+
+	mu       sync.RWMutex
+	pool     []scanner.FinalResult // 当前最优结果池，按速度排序
+	lastScan time.Time             // 上次完整扫描完成的时间
+}
+
+// New 创建常驻服务
+func New(conf utils.Config) *Daemon {
+	d := &Daemon{conf: conf, progress: &scanner.Progress{}, runCtx: context.Background()}
+	d.progress.SetStage("idle")
+	return d
+}
+
+// Status 常驻服务的状态
+type Status struct {
+	scanner.ProgressSnapshot
+	PoolSize int       `json:"pool_size"`
+	LastScan time.Time `json:"last_scan,omitzero"`
+}
+
+// Status 返回当前阶段、扫描进度和结果池概况
+func (d *Daemon) Status() Status {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return Status{
+		ProgressSnapshot: d.progress.Snapshot(),
+		PoolSize:         len(d.pool),
+		LastScan:         d.lastScan,
+	}
+}
+
+// Best 返回当前结果池的副本
+func (d *Daemon) Best() []scanner.FinalResult {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]scanner.FinalResult(nil), d.pool...)
+}
+
+// Run 立即执行一次完整扫描，之后按 Interval 定期扫描、按 Recheck 定期复查，直到 ctx 取消
+func (d *Daemon) Run(ctx context.Context) error {
+	fmt.Printf("常驻模式启动：每 %v 完整扫描一次，复查间隔 %v\n", d.conf.Interval, d.conf.Recheck)
+	d.runCtx = ctx
+
+	if d.conf.History != "" {
+		store, err := history.Open(d.conf.History)
+		if err != nil {
+			return err
+		}
+		d.history = store
+		// 等 HTTP 触发的扫描结束后再关闭数据库
+		defer func() {
+			d.scanMu.Lock()
+			defer d.scanMu.Unlock()
+			store.Close()
+		}()
+	}
+
+	if d.conf.Listen != "" {
+		if err := d.serveHTTP(ctx, d.conf.Listen); err != nil {
+			return err
+		}
+	}
+
+	d.Scan(ctx)
+
+	scanTicker := time.NewTicker(d.conf.Interval)
+	defer scanTicker.Stop()
+
+	// 复查间隔为 0 时使用一个永远不会触发的通道
+	var recheckC <-chan time.Time
+	if d.conf.Recheck > 0 {
+		recheckTicker := time.NewTicker(d.conf.Recheck)
+		defer recheckTicker.Stop()
+		recheckC = recheckTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("常驻模式已停止")
+			return nil
+		case <-scanTicker.C:
+			d.Scan(ctx)
+		case <-recheckC:
+			d.Recheck(ctx)
+		}
+	}
+}
+
+// Scan 执行一次完整的 ParseIP → RunScanPool → RunDeepTest 流程
+// 只有搜到结果时才替换结果池并写入文件；已有扫描在进行时直接返回
+func (d *Daemon) Scan(ctx context.Context) {
+	if !d.scanMu.TryLock() {
+		fmt.Println("上一次扫描尚未结束，跳过本次计划扫描")
+		return
+	}
+	d.scan(ctx, d.conf)
+}
+
+// TriggerScan 使用覆盖后的配置在后台启动一次完整扫描
+// overrides 的 key 与配置文件相同，只允许 scanKeys 中的配置项；已有扫描在进行时返回 ErrBusy
+func (d *Daemon) TriggerScan(overrides map[string]interface{}) error {
+	for _, key := range slices.Sorted(maps.Keys(overrides)) {
+		if !scanKeys[key] {
+			return fmt.Errorf("请求参数: 配置项 %q 不允许通过 HTTP 修改", key)
+		}
+	}
+	conf := d.conf
+	if err := utils.ApplyValues(&conf, overrides, "请求参数"); err != nil {
+		return err
+	}
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	if !d.scanMu.TryLock() {
+		return ErrBusy
+	}
+	go d.scan(d.runCtx, conf)
+	return nil
+}
+
+// scan 是 Scan 的实现，调用前必须已持有 scanMu
+func (d *Daemon) scan(ctx context.Context, conf utils.Config) {
+	defer d.scanMu.Unlock()
+	defer d.progress.SetStage("idle")
+
+	fmt.Printf("\n[%s] 开始完整扫描\n", time.Now().Format("2006-01-02 15:04:05"))
+
+	// 指定了种子（-seed 或 POST /scan 的 seed）时每次扫描都从该种子重新抽样
+	if conf.Seed != 0 {
+		utils.SetSeed(conf.Seed)
+		fmt.Printf("随机种子: %d\n", conf.Seed)
+	}
+
+	// 常驻模式每次都重新抽样，不写断点也不从断点恢复
+	conf.Checkpoint, conf.Resume = "", ""
+
+	d.progress.SetStage("parse")
+	exclude, blocklist, err := utils.LoadExclusions(conf)
+	if err != nil {
+		fmt.Printf("读取排除列表失败: %v\n", err)
+		return
+	}
+	sampleOpts := utils.SampleOptions{Exclude: exclude}
+
+	var scores *history.Scores
+	if d.history != nil && conf.HistoryRank {
+		if scores, err = d.history.Scores(); err != nil {
+			fmt.Printf("读取历史信誉失败: %v\n", err)
+		} else {
+			sampleOpts.Reputation = scores
+		}
+	}
+	scanOpts := conf.ScanOptions()
+	scanOpts.Progress = d.progress
+	if scores != nil {
+		scanOpts.Reputation = scores.IPScore
+	}
+	if d.history != nil {
+		scanOpts.OnResult = d.history.RecordScan
+		defer d.flushHistory()
+	}
+	candidates := utils.RefineScan(ctx, conf, sampleOpts, scanOpts)
+	if ctx.Err() != nil {
+		return
+	}
+	if conf.WSPath != "" {
+		wsOpts := conf.WSOptions()
+		wsOpts.Progress = d.progress
+		candidates = scanner.RunWSCheck(ctx, candidates, wsOpts)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
+	deepOpts := conf.DeepTestOptions()
+	deepOpts.Progress = d.progress
+	deepOpts.OnResult = func(r scanner.FinalResult, ok bool) {
+		if d.history != nil {
+			d.history.RecordDeep(r, ok)
+		}
+		if blocklist != nil {
+			blocklist.RecordDeep(r, ok)
+		}
+	}
+	if scores != nil {
+		deepOpts.Reputation = scores.IPScore
+	}
+	results := scanner.RunDeepTest(ctx, deepOpts, candidates)
+	if blocklist != nil {
+		if _, err := blocklist.Save(); err != nil {
+			fmt.Printf("保存屏蔽列表失败: %v\n", err)
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if len(results) == 0 {
+		fmt.Println("本次未搜到优质 IP，保留旧的结果池。")
+		return
+	}
+
+	d.mu.Lock()
+	d.pool = results
+	d.lastScan = time.Now()
+	d.mu.Unlock()
+
+	fmt.Printf("完整扫描完成，结果池更新为 %d 个 IP\n", len(results))
+	d.progress.SetStage("save")
+	d.save(conf, results)
+}
+
+// Recheck 对结果池中的 IP 重新探测一次，剔除已经失效的 IP
+func (d *Daemon) Recheck(ctx context.Context) {
+	pool := d.Best()
+	if len(pool) == 0 {
+		return
+	}
+
+	opts := d.conf.ScanOptions()
+	alive := make([]bool, len(pool))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, d.conf.WorkerCount)
+	for i, r := range pool {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, ip string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res := scanner.ScanIP(ctx, ip, opts)
+			alive[i] = res.Success()
+			if d.history != nil && ctx.Err() == nil {
+				d.history.RecordScan(res)
+			}
+		}(i, r.Addr())
+	}
+	wg.Wait()
+	if d.history != nil {
+		d.flushHistory()
+	}
+
+	// 中断时探测结果不可信，不修改结果池
+	if ctx.Err() != nil {
+		return
+	}
+
+	var kept []scanner.FinalResult
+	for i, r := range pool {
+		if alive[i] {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(pool) {
+		return
+	}
+	fmt.Printf("[%s] 复查剔除 %d 个失效 IP，剩余 %d 个\n", time.Now().Format("2006-01-02 15:04:05"), len(pool)-len(kept), len(kept))
+
+	// 复查期间可能已经完成了新的完整扫描，此时以新结果为准
+	d.mu.Lock()
+	if !sameIPs(d.pool, pool) {
+		d.mu.Unlock()
+		return
+	}
+	d.pool = kept
+	d.mu.Unlock()
+
+	if len(kept) > 0 {
+		d.save(d.conf, kept)
+	}
+}
+
+// save 以原子方式写入结果文件（临时文件 + 重命名），客户端不会读到写了一半的文件
+func (d *Daemon) save(conf utils.Config, results []scanner.FinalResult) {
+	files, err := utils.SaveOutputs(conf, results)
+	if err != nil {
+		fmt.Printf("保存结果失败: %v\n", err)
+	}
+	if conf.AppendMode {
+		if err := utils.AppendToJSONFile(conf.OutputFilePath, results); err != nil {
+			fmt.Printf("保存文件失败: %v\n", err)
+		} else {
+			files = append(files, conf.OutputFilePath)
+		}
+	}
+	if len(files) > 0 {
+		fmt.Printf("结果已保存至 %v\n", files)
+	}
+}
+
+// flushHistory 将本轮记录写入历史数据库
+func (d *Daemon) flushHistory() {
+	if err := d.history.Flush(); err != nil {
+		fmt.Printf("写入历史数据库失败: %v\n", err)
+	}
+}
+
+// sameIPs 判断两个结果列表的 IP 顺序是否一致
+func sameIPs(a, b []scanner.FinalResult) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Addr() != b[i].Addr() {
+			return false
+		}
+	}
+	return true
+}
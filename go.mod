@@ -2,11 +2,21 @@ module github.com/gzjjjfree/cf-scanner
 
 go 1.24.4
 
-require github.com/schollz/progressbar/v3 v3.18.0
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/quic-go/quic-go v0.59.1
+	github.com/schollz/progressbar/v3 v3.18.0
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/net v0.43.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
 	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/term v0.38.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
 )
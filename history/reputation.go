@@ -0,0 +1,102 @@
+package history
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Reputation 一个 IP 或网段的历史信誉
+type Reputation struct {
+	Score       float64   `json:"score"` // 0~1，按半衰期加权的成功率，无记录时为 0.5
+	Total       int       `json:"total"`
+	Success     int       `json:"success"`
+	LastSeen    time.Time `json:"last_seen,omitzero"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+}
+
+// Scores 某一时刻所有 IP 和网段的信誉快照，只读，可并发使用
+type Scores struct {
+	ips     map[string]*stat
+	subnets map[string]*stat
+}
+
+// Scores 读取累计统计，计算所有 IP 和网段的信誉
+func (s *Store) Scores() (*Scores, error) {
+	now := time.Now()
+	scores := &Scores{ips: make(map[string]*stat), subnets: make(map[string]*stat)}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(statsBucket).ForEach(func(k, v []byte) error {
+			st := &stat{}
+			if err := json.Unmarshal(v, st); err != nil {
+				return err
+			}
+			st.decay(now)
+			ip := string(k)
+			scores.ips[ip] = st
+
+			subnet := SubnetOf(ip)
+			if scores.subnets[subnet] == nil {
+				scores.subnets[subnet] = &stat{Updated: now}
+			}
+			scores.subnets[subnet].merge(st)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// IP 返回单个 IP 的信誉
+func (sc *Scores) IP(ip string) Reputation {
+	if st, ok := sc.ips[ip]; ok {
+		return st.reputation()
+	}
+	return Reputation{Score: score(0, 0)}
+}
+
+// Subnet 返回 IP 所在 /24（IPv6 为 /48）网段的信誉
+func (sc *Scores) Subnet(ip string) Reputation {
+	if st, ok := sc.subnets[SubnetOf(ip)]; ok {
+		return st.reputation()
+	}
+	return Reputation{Score: score(0, 0)}
+}
+
+// IPScore 返回 IP 的信誉分，可作为 DeepTestOptions.Reputation 使用
+func (sc *Scores) IPScore(ip string) float64 {
+	return sc.IP(ip).Score
+}
+
+// SubnetScore 返回 IP 所在网段的信誉分
+func (sc *Scores) SubnetScore(ip string) float64 {
+	return sc.Subnet(ip).Score
+}
+
+// GoodIPs 返回网段内曾经成功过、且信誉高于平均的 IP，按信誉从高到低排列，最多 limit 个
+func (sc *Scores) GoodIPs(ipnet *net.IPNet, limit int) []string {
+	var ips []string
+	for ip, st := range sc.ips {
+		if st.Success > 0 && st.reputation().Score > 0.5 && ipnet.Contains(net.ParseIP(ip)) {
+			ips = append(ips, ip)
+		}
+	}
+
+	sort.Slice(ips, func(i, j int) bool {
+		si, sj := sc.IPScore(ips[i]), sc.IPScore(ips[j])
+		if si != sj {
+			return si > sj
+		}
+		return ips[i] < ips[j]
+	})
+	if len(ips) > limit {
+		ips = ips[:limit]
+	}
+	return ips
+}
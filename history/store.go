@@ -0,0 +1,284 @@
+// Package history 将每次探测和测速的结果保存到本地 bbolt 数据库，
+// 并据此计算每个 IP 和每个 /24（IPv6 为 /48）网段的历史信誉
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	recordsBucket = []byte("records") // 每个 IP 一个子桶，key 为 时间戳+序号，value 为 Record
+	statsBucket   = []byte("stats")   // 每个 IP 一条累计统计，用于快速计算信誉
+)
+
+const (
+	// halfLife 信誉的半衰期，越早的记录权重越低
+	halfLife = 72 * time.Hour
+	// retention 明细记录的保留时间，累计统计不受影响
+	retention = 30 * 24 * time.Hour
+	// flushEvery 缓存的记录达到该数量时自动写入，避免大规模扫描把全部记录留在内存中
+	flushEvery = 1000
+)
+
+// Record 一次探测或测速的记录
+type Record struct {
+	Time  time.Time `json:"time"`
+	Stage string    `json:"stage"` // scan: 握手探测；deep: 下载测速
+	OK    bool      `json:"ok"`
+	scanner.ResultDetail
+}
+
+// Store 扫描历史数据库，写入先缓存在内存中，Flush 时（或缓存达到 flushEvery 条时）一次性提交
+type Store struct {
+	db *bolt.DB
+
+	mu       sync.Mutex
+	pending  []Record
+	flushErr error // 自动写入时的错误，由下一次 Flush 返回
+}
+
+// Open 打开（或创建）历史数据库
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开历史数据库 %s 失败: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{recordsBucket, statsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close 写入尚未提交的记录并关闭数据库
+func (s *Store) Close() error {
+	err := s.Flush()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// RecordScan 记录一次握手探测的结果，可作为 ScanOptions.OnResult 使用
+func (s *Store) RecordScan(r scanner.FinalResult) {
+	s.add(Record{Time: time.Now(), Stage: "scan", OK: r.Success(), ResultDetail: scanner.ResultDetail(r)})
+}
+
+// RecordDeep 记录一次下载测速的结果，可作为 DeepTestOptions.OnResult 使用
+func (s *Store) RecordDeep(r scanner.FinalResult, ok bool) {
+	s.add(Record{Time: time.Now(), Stage: "deep", OK: ok, ResultDetail: scanner.ResultDetail(r)})
+}
+
+func (s *Store) add(rec Record) {
+	s.mu.Lock()
+	s.pending = append(s.pending, rec)
+	full := len(s.pending) >= flushEvery
+	s.mu.Unlock()
+
+	if full {
+		if err := s.Flush(); err != nil {
+			s.mu.Lock()
+			s.flushErr = err
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Flush 在一个事务中写入缓存的记录、更新累计统计，并清理过期的明细记录
+// 之前自动写入失败时返回该错误
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	flushErr := s.flushErr
+	s.flushErr = nil
+	s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(recordsBucket)
+		stats := tx.Bucket(statsBucket)
+
+		for _, rec := range pending {
+			if err := putRecord(records, rec); err != nil {
+				return err
+			}
+			if err := updateStat(stats, rec); err != nil {
+				return err
+			}
+		}
+		return prune(records, time.Now().Add(-retention))
+	})
+	if err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// putRecord 将记录写入对应 IP 的子桶
+func putRecord(records *bolt.Bucket, rec Record) error {
+	bucket, err := records.CreateBucketIfNotExists([]byte(rec.IP))
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	// 时间戳在前，保证按时间顺序遍历
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(rec.Time.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return bucket.Put(key, value)
+}
+
+// prune 删除早于 before 的明细记录，删空的子桶一并删除
+func prune(records *bolt.Bucket, before time.Time) error {
+	limit := uint64(before.UnixNano())
+
+	// 遍历时不能修改父桶，先取出所有子桶名
+	var names [][]byte
+	err := records.ForEachBucket(func(name []byte) error {
+		names = append(names, append([]byte(nil), name...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		c := records.Bucket(name).Cursor()
+		k, _ := c.First()
+		for ; k != nil && binary.BigEndian.Uint64(k) < limit; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		if k == nil {
+			if err := records.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stat 单个 IP 的累计统计
+// OKWeight / Weight 为按半衰期衰减后的成功次数和总次数，截止到 Updated
+type stat struct {
+	OKWeight    float64   `json:"ok_weight"`
+	Weight      float64   `json:"weight"`
+	Updated     time.Time `json:"updated"`
+	Total       int       `json:"total"`
+	Success     int       `json:"success"`
+	LastSeen    time.Time `json:"last_seen"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	Colo        string    `json:"colo,omitempty"`
+}
+
+// decay 将衰减后的次数推进到 now
+func (st *stat) decay(now time.Time) {
+	if !st.Updated.IsZero() && now.After(st.Updated) {
+		factor := math.Pow(0.5, float64(now.Sub(st.Updated))/float64(halfLife))
+		st.OKWeight *= factor
+		st.Weight *= factor
+	}
+	st.Updated = now
+}
+
+// add 计入一次结果
+func (st *stat) add(rec Record) {
+	st.decay(rec.Time)
+	st.Weight++
+	st.Total++
+	st.LastSeen = rec.Time
+	if rec.OK {
+		st.OKWeight++
+		st.Success++
+		st.LastSuccess = rec.Time
+	}
+	if rec.Colo != "" {
+		st.Colo = rec.Colo
+	}
+}
+
+// merge 合并另一个统计（两者须已衰减到同一时间）
+func (st *stat) merge(o *stat) {
+	st.OKWeight += o.OKWeight
+	st.Weight += o.Weight
+	st.Total += o.Total
+	st.Success += o.Success
+	if o.LastSeen.After(st.LastSeen) {
+		st.LastSeen = o.LastSeen
+	}
+	if o.LastSuccess.After(st.LastSuccess) {
+		st.LastSuccess = o.LastSuccess
+	}
+}
+
+// reputation 根据衰减后的成功率计算信誉
+func (st *stat) reputation() Reputation {
+	return Reputation{
+		Score:       score(st.OKWeight, st.Weight),
+		Total:       st.Total,
+		Success:     st.Success,
+		LastSeen:    st.LastSeen,
+		LastSuccess: st.LastSuccess,
+	}
+}
+
+// score 加一个成功、一个失败的先验后的成功率，没有记录时为 0.5
+func score(okWeight, weight float64) float64 {
+	return (okWeight + 1) / (weight + 2)
+}
+
+// updateStat 将记录计入对应 IP 的累计统计
+func updateStat(stats *bolt.Bucket, rec Record) error {
+	var st stat
+	if value := stats.Get([]byte(rec.IP)); value != nil {
+		if err := json.Unmarshal(value, &st); err != nil {
+			return err
+		}
+	}
+	st.add(rec)
+
+	value, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return stats.Put([]byte(rec.IP), value)
+}
+
+// SubnetOf 返回 IP 所在的 /24（IPv6 为 /48）网段，无效 IP 返回空串
+func SubnetOf(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if ip4 := parsed.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
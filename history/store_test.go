@@ -0,0 +1,235 @@
+package history
+
+import (
+	"math"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+)
+
+func openTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+// almostEqual 比较浮点数，容忍衰减计算中的舍入误差
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestStoreFlushAndReopen(t *testing.T) {
+	s, path := openTestStore(t)
+
+	s.RecordDeep(scanner.FinalResult{IP: "1.1.1.1", Colo: "HKG", DownloadMBs: 50}, true)
+	s.RecordDeep(scanner.FinalResult{IP: "1.1.1.1"}, false)
+	s.RecordDeep(scanner.FinalResult{IP: "1.1.1.1", Colo: "HKG"}, true)
+
+	// Flush 之前记录只在内存中
+	if records, err := s.Records("1.1.1.1", time.Unix(0, 0)); err != nil || len(records) != 0 {
+		t.Fatalf("Flush 前读到 %d 条记录，错误 %v", len(records), err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开后记录和统计仍在
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	records, err := s.Records("1.1.1.1", time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || !records[0].OK || records[1].OK || records[0].DownloadMBs != 50 {
+		t.Fatalf("记录 = %+v", records)
+	}
+
+	scores, err := s.Scores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := scores.IP("1.1.1.1")
+	// 三条记录几乎同时写入，衰减可以忽略：(2+1)/(3+2)
+	if rep.Total != 3 || rep.Success != 2 || !almostEqual(rep.Score, 0.6) {
+		t.Errorf("信誉 = %+v，期望 3 次中成功 2 次、得分 0.6", rep)
+	}
+	if got := scores.IP("9.9.9.9"); got.Score != 0.5 || got.Total != 0 {
+		t.Errorf("没有记录的 IP 信誉 = %+v，期望 0.5", got)
+	}
+}
+
+func TestStoreAutoFlush(t *testing.T) {
+	s, _ := openTestStore(t)
+
+	// 缓存达到 flushEvery 条时自动写入，不必等到 Close
+	for i := 0; i < flushEvery; i++ {
+		s.RecordDeep(scanner.FinalResult{IP: "1.0.0.1"}, i%2 == 0)
+	}
+	s.mu.Lock()
+	pending := len(s.pending)
+	s.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("达到 %d 条后仍有 %d 条记录未写入", flushEvery, pending)
+	}
+
+	records, err := s.Records("1.0.0.1", time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != flushEvery {
+		t.Errorf("自动写入 %d 条记录，期望 %d", len(records), flushEvery)
+	}
+
+	// 未达到阈值的记录继续留在缓存中
+	s.RecordDeep(scanner.FinalResult{IP: "1.0.0.1"}, true)
+	if records, _ := s.Records("1.0.0.1", time.Unix(0, 0)); len(records) != flushEvery {
+		t.Errorf("未达到阈值时写入了 %d 条记录", len(records))
+	}
+}
+
+func TestStatHalfLife(t *testing.T) {
+	now := time.Now()
+
+	// 一个半衰期前成功一次，权重衰减为 0.5
+	var st stat
+	st.add(Record{Time: now.Add(-halfLife), OK: true})
+	st.decay(now)
+	if !almostEqual(st.OKWeight, 0.5) || !almostEqual(st.Weight, 0.5) {
+		t.Fatalf("一个半衰期后权重 = %v/%v，期望 0.5/0.5", st.OKWeight, st.Weight)
+	}
+
+	// 之后失败一次：(0.5+1)/(1.5+2)
+	st.add(Record{Time: now, OK: false})
+	if got := st.reputation(); !almostEqual(got.Score, 1.5/3.5) || got.Total != 2 || got.Success != 1 {
+		t.Errorf("信誉 = %+v，期望得分 %.4f", got, 1.5/3.5)
+	}
+
+	// 时间倒退不会放大权重
+	st.decay(now.Add(-time.Hour))
+	if !almostEqual(st.Weight, 1.5) {
+		t.Errorf("时间倒退后权重 = %v，期望保持 1.5", st.Weight)
+	}
+}
+
+func TestScoresHalfLife(t *testing.T) {
+	s, _ := openTestStore(t)
+	now := time.Now()
+
+	// 旧的失败比新的成功权重低，两个 IP 的原始成功率相同但信誉不同
+	s.add(Record{Time: now.Add(-2 * halfLife), Stage: "deep", OK: false, ResultDetail: scanner.ResultDetail{IP: "1.0.0.1"}})
+	s.add(Record{Time: now, Stage: "deep", OK: true, ResultDetail: scanner.ResultDetail{IP: "1.0.0.1"}})
+	s.add(Record{Time: now.Add(-2 * halfLife), Stage: "deep", OK: true, ResultDetail: scanner.ResultDetail{IP: "1.0.0.2"}})
+	s.add(Record{Time: now, Stage: "deep", OK: false, ResultDetail: scanner.ResultDetail{IP: "1.0.0.2"}})
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	scores, err := s.Scores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两个半衰期前的记录权重为 0.25
+	if got := scores.IPScore("1.0.0.1"); !almostEqual(got, 2/3.25) {
+		t.Errorf("1.0.0.1 信誉 = %.4f，期望 %.4f", got, 2/3.25)
+	}
+	if got := scores.IPScore("1.0.0.2"); !almostEqual(got, 1.25/3.25) {
+		t.Errorf("1.0.0.2 信誉 = %.4f，期望 %.4f", got, 1.25/3.25)
+	}
+	// 网段合并两个 IP 的衰减后次数：(1.25+1)/(2.5+2)
+	if got := scores.SubnetScore("1.0.0.200"); !almostEqual(got, 0.5) {
+		t.Errorf("网段信誉 = %.4f，期望 0.5", got)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	s, _ := openTestStore(t)
+	now := time.Now()
+
+	s.add(Record{Time: now.Add(-retention - time.Hour), Stage: "scan", ResultDetail: scanner.ResultDetail{IP: "1.0.0.1"}})
+	s.add(Record{Time: now, Stage: "scan", ResultDetail: scanner.ResultDetail{IP: "1.0.0.1"}})
+	s.add(Record{Time: now.Add(-retention - time.Hour), Stage: "scan", ResultDetail: scanner.ResultDetail{IP: "1.0.0.2"}})
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 过期的明细被删除，累计统计保留
+	if records, _ := s.Records("1.0.0.0/24", time.Unix(0, 0)); len(records) != 1 || records[0].IP != "1.0.0.1" {
+		t.Errorf("清理后剩余记录 = %+v", records)
+	}
+	scores, err := s.Scores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scores.IP("1.0.0.2"); got.Total != 1 {
+		t.Errorf("明细清理后累计统计 = %+v，期望保留 1 次", got)
+	}
+}
+
+func TestGoodIPs(t *testing.T) {
+	s, _ := openTestStore(t)
+
+	record := func(ip string, results ...bool) {
+		for _, ok := range results {
+			s.RecordDeep(scanner.FinalResult{IP: ip}, ok)
+		}
+	}
+	record("1.0.0.1", true, true, true) // 0.8
+	record("1.0.0.2", true)             // 0.667
+	record("1.0.0.3", true, false)      // 0.5，不高于平均
+	record("1.0.0.4", false, false)     // 从未成功
+	record("1.0.0.5", true, true)       // 0.75
+	record("1.0.1.1", true, true, true) // 不在网段内
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	scores, err := s.Scores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ipnet, _ := net.ParseCIDR("1.0.0.0/24")
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{10, []string{"1.0.0.1", "1.0.0.5", "1.0.0.2"}},
+		{2, []string{"1.0.0.1", "1.0.0.5"}},
+		{0, nil},
+	}
+	for _, tt := range tests {
+		got := scores.GoodIPs(ipnet, tt.limit)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("GoodIPs(limit=%d) = %v，期望 %v", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSubnetOf(t *testing.T) {
+	tests := map[string]string{
+		"1.2.3.4":        "1.2.3.0/24",
+		"2606:4700:1::1": "2606:4700:1::/48",
+		"::ffff:1.2.3.4": "1.2.3.0/24",
+		"not an ip":      "",
+	}
+	for ip, want := range tests {
+		if got := SubnetOf(ip); got != want {
+			t.Errorf("SubnetOf(%q) = %q，期望 %q", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// parseTarget 将查询目标解析为网段，单个 IP 视为 /32（IPv6 为 /128）
+func parseTarget(target string) (*net.IPNet, bool, error) {
+	if strings.Contains(target, "/") {
+		_, ipnet, err := net.ParseCIDR(target)
+		return ipnet, false, err
+	}
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return nil, false, fmt.Errorf("无效的 IP 或网段 %q", target)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, true, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, true, nil
+}
+
+// Records 返回 IP 或网段内 since 之后的所有明细记录，按时间排序
+func (s *Store) Records(target string, since time.Time) ([]Record, error) {
+	ipnet, _, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	from := make([]byte, 8)
+	binary.BigEndian.PutUint64(from, uint64(since.UnixNano()))
+
+	var records []Record
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).ForEachBucket(func(name []byte) error {
+			if !ipnet.Contains(net.ParseIP(string(name))) {
+				return nil
+			}
+			c := tx.Bucket(recordsBucket).Bucket(name).Cursor()
+			for k, v := c.Seek(from); k != nil; k, v = c.Next() {
+				var rec Record
+				if err := json.Unmarshal(v, &rec); err != nil {
+					return err
+				}
+				records = append(records, rec)
+			}
+			return nil
+		})
+	})
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, err
+}
+
+// dayTrend 一天内的汇总
+type dayTrend struct {
+	probes, probeOK int
+	latencySum      int64
+	tests, testOK   int
+	speedSum        float64
+	colos           []string
+}
+
+// WriteTrend 输出 IP 或网段最近 days 天的信誉和按天汇总的趋势
+func (s *Store) WriteTrend(w io.Writer, target string, days int) error {
+	ipnet, single, err := parseTarget(target)
+	if err != nil {
+		return err
+	}
+
+	scores, err := s.Scores()
+	if err != nil {
+		return err
+	}
+	records, err := s.Records(target, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return err
+	}
+
+	// 信誉概况
+	if single {
+		ip := ipnet.IP.String()
+		fmt.Fprintf(w, "IP %s 信誉: %s\n", ip, formatReputation(scores.IP(ip)))
+		fmt.Fprintf(w, "所在网段 %s 信誉: %s\n", SubnetOf(ip), formatReputation(scores.Subnet(ip)))
+	} else {
+		fmt.Fprintf(w, "网段 %s 信誉: %s\n", ipnet, formatReputation(scores.within(ipnet)))
+	}
+
+	// 按天汇总
+	trends := make(map[string]*dayTrend)
+	var dates []string
+	for _, rec := range records {
+		date := rec.Time.Local().Format("2006-01-02")
+		t, ok := trends[date]
+		if !ok {
+			t = &dayTrend{}
+			trends[date] = t
+			dates = append(dates, date)
+		}
+
+		switch rec.Stage {
+		case "scan":
+			t.probes++
+			if rec.OK {
+				t.probeOK++
+				t.latencySum += rec.RawLatency
+			}
+		case "deep":
+			t.tests++
+			if rec.OK {
+				t.testOK++
+				t.speedSum += rec.DownloadMBs
+			}
+		}
+		if rec.Colo != "" && !contains(t.colos, rec.Colo) {
+			t.colos = append(t.colos, rec.Colo)
+		}
+	}
+
+	fmt.Fprintf(w, "\n最近 %d 天趋势:\n", days)
+	if len(dates) == 0 {
+		fmt.Fprintln(w, "  无记录")
+	} else {
+		// 中文占两个字符宽度，表头直接按列宽写出
+		fmt.Fprintln(w, "  日期          探测  成功率  平均延迟    测速    平均速度  数据中心")
+	}
+	for _, date := range dates {
+		t := trends[date]
+		rate, latency, speed := "-", "-", "-"
+		if t.probes > 0 {
+			rate = fmt.Sprintf("%.0f%%", float64(t.probeOK)/float64(t.probes)*100)
+		}
+		if t.probeOK > 0 {
+			latency = fmt.Sprintf("%dms", t.latencySum/int64(t.probeOK))
+		}
+		if t.testOK > 0 {
+			speed = fmt.Sprintf("%.2fMbps", t.speedSum/float64(t.testOK))
+		}
+		fmt.Fprintf(w, "  %-10s  %6d  %6s  %8s  %6d  %10s  %s\n", date, t.probes, rate, latency, t.tests, speed, strings.Join(t.colos, ","))
+	}
+
+	// 网段查询时列出信誉最高的 IP
+	if !single {
+		good := scores.GoodIPs(ipnet, 10)
+		if len(good) > 0 {
+			fmt.Fprintln(w, "\n信誉最高的 IP:")
+		}
+		for i, ip := range good {
+			fmt.Fprintf(w, "  %2d. %-39s %s\n", i+1, ip, formatReputation(scores.IP(ip)))
+		}
+	}
+	return nil
+}
+
+// within 汇总网段内所有 IP 的信誉
+func (sc *Scores) within(ipnet *net.IPNet) Reputation {
+	total := &stat{}
+	for ip, st := range sc.ips {
+		if ipnet.Contains(net.ParseIP(ip)) {
+			total.merge(st)
+		}
+	}
+	return total.reputation()
+}
+
+// formatReputation 以 "0.83 (成功 10/12，最近成功 ...)" 的形式输出信誉
+func formatReputation(r Reputation) string {
+	if r.Total == 0 {
+		return fmt.Sprintf("%.2f (无记录)", r.Score)
+	}
+	s := fmt.Sprintf("%.2f (成功 %d/%d，最近探测 %s", r.Score, r.Success, r.Total, r.LastSeen.Local().Format("2006-01-02 15:04"))
+	if !r.LastSuccess.IsZero() {
+		s += "，最近成功 " + r.LastSuccess.Local().Format("2006-01-02 15:04")
+	}
+	return s + ")"
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
 
+	"github.com/gzjjjfree/cf-scanner/daemon"
+	"github.com/gzjjjfree/cf-scanner/history"
 	"github.com/gzjjjfree/cf-scanner/scanner"
 	"github.com/gzjjjfree/cf-scanner/utils"
 )
@@ -24,6 +30,15 @@ func main() {
 		return
 	}
 
+	// 打印合并后的最终配置
+	if conf.PrintConfig {
+		if err := utils.PrintConfig(os.Stdout, conf); err != nil {
+			fmt.Fprintf(os.Stderr, "输出配置失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 如果用户输入了 -help
 	if conf.Help {
 		flag.Usage()
@@ -33,7 +48,9 @@ func main() {
 	// 自定义帮助信息显示方式
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Cloudflare 优选 IP 扫描工具\n\n")
-		fmt.Fprintf(os.Stderr, "用法:\n  ./cf-scanner [options]\n\n")
+		fmt.Fprintf(os.Stderr, "用法:\n  ./cf-scanner [options]\n  ./cf-scanner serve [options]    常驻模式，定期扫描并复查结果\n")
+		fmt.Fprintf(os.Stderr, "  ./cf-scanner history <IP 或网段> -history history.db    查询历史信誉和趋势\n")
+		fmt.Fprintf(os.Stderr, "  ./cf-scanner plan [plan.txt] -seed 42    只输出抽样计划，不探测\n\n")
 		fmt.Fprintf(os.Stderr, "参数说明:\n")
 		flag.VisitAll(func(f *flag.Flag) {
 			fmt.Fprintf(os.Stderr, "  -%-10s %s (默认值: %v)\n", f.Name, f.Usage, f.DefValue)
@@ -41,14 +58,138 @@ func main() {
 		fmt.Fprintf(os.Stderr, "\n示例:\n  ./cf-scanner -d www.speed.com/10mb.bin -o c:\\ips\n")
 	}
 
-	ipGroups, actualTaskCount := utils.ParseIP(conf)
+	// 固定随机种子，使抽样可以复现
+	if conf.Seed != 0 {
+		utils.SetSeed(conf.Seed)
+	}
+
+	// 第一次 Ctrl+C 结束当前阶段并保留结果，第二次立即退出
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	watchInterrupt(stop)
+
+	// 常驻模式
+	if conf.Command == "serve" {
+		if err := daemon.New(conf).Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 扫描历史数据库
+	var store *history.Store
+	if conf.History != "" {
+		var err error
+		if store, err = history.Open(conf.History); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		defer func() {
+			if err := store.Close(); err != nil {
+				fmt.Printf("写入历史数据库失败: %v\n", err)
+			}
+		}()
+	}
+
+	// 查询历史
+	if conf.Command == "history" {
+		if err := store.WriteTrend(os.Stdout, conf.Args[0], conf.HistoryDays); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 不覆盖上次中断留下的断点
+	if conf.Command == "" {
+		if err := utils.CheckCheckpoint(conf); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// 排除列表和自动屏蔽列表
+	exclude, blocklist, err := utils.LoadExclusions(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if exclude.Len() > 0 {
+		fmt.Printf("排除列表共 %d 个网段/IP\n", exclude.Len())
+	}
+	sampleOpts := utils.SampleOptions{Exclude: exclude}
+
+	// 参考历史信誉抽样和排序
+	var scores *history.Scores
+	if conf.HistoryRank {
+		if scores, err = store.Scores(); err != nil {
+			fmt.Printf("读取历史信誉失败: %v\n", err)
+		} else {
+			sampleOpts.Reputation = scores
+		}
+	}
 
-	finalResults := scanner.RunScanPool(ipGroups, conf.WorkerCount, conf.Domain, conf.LatencyLimit, actualTaskCount)
+	// 只输出抽样计划
+	if conf.Command == "plan" {
+		filename := "plan.txt"
+		if len(conf.Args) > 0 {
+			filename = conf.Args[0]
+		}
+		ipGroups, total := utils.ParseIP(conf, sampleOpts)
+		if err := utils.SavePlan(filename, conf, ipGroups); err != nil {
+			fmt.Fprintf(os.Stderr, "保存抽样计划失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("抽样计划已保存至 %s（%d 个 IP，种子 %d），可使用 -f %s 按该计划扫描\n", filename, total, utils.Seed(), filename)
+		return
+	}
+
+	// 从断点恢复时使用断点中记录的种子
+	if conf.Resume == "" {
+		fmt.Printf("随机种子: %d（使用 -seed %d 可复现本次抽样）\n", utils.Seed(), utils.Seed())
+	}
+	scanOpts := conf.ScanOptions()
+	if store != nil {
+		scanOpts.OnResult = store.RecordScan
+	}
+	if scores != nil {
+		scanOpts.Reputation = scores.IPScore
+	}
+	if conf.Score != "" {
+		fmt.Printf("综合得分: %s\n", scanOpts.Scorer)
+	}
+	finalResults := utils.RefineScan(ctx, conf, sampleOpts, scanOpts)
+	flushHistory(store)
+
+	// 扫描阶段被中断时，仍对已找到的 IP 完成验证和测速
+	deepCtx := ctx
+	if ctx.Err() != nil {
+		deepCtx = context.Background()
+	}
+
+	// WebSocket 升级验证，剔除握手成功但无法升级的 IP
+	if conf.WSPath != "" {
+		fmt.Printf("\n--- 开始 WebSocket 升级验证 (%s) ---\n", conf.WSPath)
+		finalResults = scanner.RunWSCheck(deepCtx, finalResults, conf.WSOptions())
+	}
 
 	// 输出前 outCount 名
 	fmt.Printf("\n--- 优选结果 Top %v 最后结果 %v---\n", conf.OutCount*2, len(finalResults))
 	for i := 0; i < len(finalResults) && i < conf.OutCount*2; i++ {
-		fmt.Printf("排名 %d: [%s], 延迟: %v\n", i+1, finalResults[i].IP, finalResults[i].Latency)
+		r := finalResults[i]
+		fmt.Printf("排名 %d: [%s] %s, 延迟: %v (min %d / p95 %d / max %d ms), 抖动: %.1fms, 丢包: %.0f%%",
+			i+1, r.Addr(), r.Colo, r.Latency, r.MinLatency, r.P95Latency, r.MaxLatency, r.Jitter, r.LossRate)
+		if r.ALPN != "" {
+			fmt.Printf(", ALPN: %s", r.ALPN)
+		}
+		if r.WSLatency > 0 {
+			fmt.Printf(", WS 升级: %dms", r.WSLatency)
+		}
+		if conf.Score != "" {
+			fmt.Printf(", 得分: %.3f", r.Score)
+		}
+		fmt.Println()
 	}
 
 	top := conf.OutCount * 2
@@ -58,13 +199,37 @@ func main() {
 	// 取前 outCount 名进行深度测速
 	fmt.Printf("\n--- 开始对 Top %v 进行下载测速，优选 %v 个结果 ---\n", top, conf.OutCount)
 
-	finalSorted := scanner.RunDeepTest(conf.OutCount, conf.Domain, conf.MinSpeed, finalResults)
+	deepOpts := conf.DeepTestOptions()
+	deepOpts.OnResult = func(r scanner.FinalResult, ok bool) {
+		if store != nil {
+			store.RecordDeep(r, ok)
+		}
+		if blocklist != nil {
+			blocklist.RecordDeep(r, ok)
+		}
+	}
+	if scores != nil {
+		deepOpts.Reputation = scores.IPScore
+	}
+	finalSorted := scanner.RunDeepTest(deepCtx, deepOpts, finalResults)
+	flushHistory(store)
+
+	// 测速未达标的 IP 写入自动屏蔽列表
+	if blocklist != nil {
+		if added, err := blocklist.Save(); err != nil {
+			fmt.Printf("保存屏蔽列表失败: %v\n", err)
+		} else if added > 0 {
+			fmt.Printf("%d 个测速未达标的 IP 已加入屏蔽列表 %s（%v 内不再抽样）\n", added, conf.Blocklist, conf.BlockTTL)
+		}
+	}
 
 	// 假设结果已经存储在 finalSorted 切片中
 	if len(finalSorted) > 0 {
 		// 只有当搜到的 IP 数量大于 0 时，才覆盖旧的 result.json
-		utils.SaveToCSV(conf.OutFile+".csv", finalSorted)
-		utils.SaveToJSON(conf.OutFile+".json", finalSorted)
+		files, err := utils.SaveOutputs(conf, finalSorted)
+		if err != nil {
+			fmt.Printf("保存结果失败: %v\n", err)
+		}
 		if conf.AppendMode {
 			err := utils.AppendToJSONFile(conf.OutputFilePath, finalSorted)
 			if err != nil {
@@ -73,18 +238,55 @@ func main() {
 				fmt.Printf("结果已追加至: %s\n", conf.OutputFilePath)
 			}
 		}
-		fmt.Printf("\n结果已保存至 %s.csv 和 %s.json\n", conf.OutFile, conf.OutFile)
+		fmt.Printf("\n结果已保存至 %s\n", strings.Join(files, " 和 "))
 	} else {
 		fmt.Println("本次未搜到优质 IP，保留旧的配置文件。")
 	}
 
 	fmt.Println("\n✅ 优选后的 IP:")
 	for i := 0; i < len(finalSorted); i++ {
-		fmt.Printf("排名 %d: [%s], 延迟: %v  速度: %.2f Mbps\n", i+1, finalSorted[i].IP, finalSorted[i].Latency, finalSorted[i].DownloadMBs)
+		fmt.Printf("排名 %d: [%s] %s, 延迟: %v  速度: %.2f Mbps", i+1, finalSorted[i].Addr(), finalSorted[i].Colo, finalSorted[i].Latency, finalSorted[i].DownloadMBs)
+		if finalSorted[i].AggregateMBs > 0 {
+			fmt.Printf("  聚合: %.2f Mbps", finalSorted[i].AggregateMBs)
+		}
+		if finalSorted[i].UploadMBs > 0 {
+			fmt.Printf("  上传: %.2f Mbps", finalSorted[i].UploadMBs)
+		}
+		if conf.Score != "" {
+			fmt.Printf("  得分: %.3f", finalSorted[i].Score)
+		}
+		fmt.Println()
 	}
 
 	fmt.Println("\n✅ 最终优选建议:")
 	if len(finalSorted) > 0 {
-		fmt.Printf("最佳 IP: [%s] | 预估带宽: %.2f Mbps\n", finalSorted[0].IP, finalSorted[0].DownloadMBs)
+		fmt.Printf("最佳 IP: [%s] | 预估带宽: %.2f Mbps\n", finalSorted[0].Addr(), finalSorted[0].DownloadMBs)
 	}
 }
+
+// flushHistory 将已缓存的记录写入历史数据库，避免再次中断直接退出时丢失
+func flushHistory(store *history.Store) {
+	if store == nil {
+		return
+	}
+	if err := store.Flush(); err != nil {
+		fmt.Printf("写入历史数据库失败: %v\n", err)
+	}
+}
+
+// watchInterrupt 监听 Ctrl+C / SIGTERM
+// 第一次收到信号时调用 cancel，让当前阶段优雅结束；第二次收到信号时立即退出
+func watchInterrupt(cancel context.CancelFunc) {
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		fmt.Println("\n⚠️ 收到中断信号，停止派发任务并保存已有结果（再次按 Ctrl+C 立即退出）")
+		cancel()
+
+		<-sigChan
+		fmt.Println("\n❌ 再次收到中断信号，立即退出")
+		os.Exit(130)
+	}()
+}
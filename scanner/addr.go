@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort 地址中未带端口时，探测和测速使用的端口
+const DefaultPort = 443
+
+// SplitAddr 拆分 "IP"、"IP:端口" 或 "[IPv6]:端口" 形式的地址，未带端口时返回 DefaultPort
+func SplitAddr(addr string) (string, int, error) {
+	// 不带端口的 IPv6 本身含有冒号，先按纯 IP 解析
+	if ip := net.ParseIP(strings.Trim(addr, "[]")); ip != nil {
+		return ip.String(), DefaultPort, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("无效的地址 %q", addr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", 0, fmt.Errorf("无效的 IP %q", host)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("无效的端口 %q", portStr)
+	}
+	return ip.String(), port, nil
+}
+
+// JoinAddr 组合 IP 和端口，端口为 DefaultPort（或 0）时只返回 IP
+// 扫描任务、断点和抽样计划中的地址都使用这种形式
+func JoinAddr(ip string, port int) string {
+	if port == 0 || port == DefaultPort {
+		return ip
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// Addr 返回结果对应的探测地址，格式同 JoinAddr
+func (r FinalResult) Addr() string {
+	return JoinAddr(r.IP, r.Port)
+}
+
+// dialAddr 返回拨号用的网络类型和 "IP:端口"
+func dialAddr(addr string) (string, string, error) {
+	ip, port, err := SplitAddr(addr)
+	if err != nil {
+		return "", "", err
+	}
+	network := "tcp"
+	if strings.Contains(ip, ":") {
+		network = "tcp6"
+	}
+	return network, net.JoinHostPort(ip, strconv.Itoa(port)), nil
+}
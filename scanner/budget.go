@@ -0,0 +1,138 @@
+package scanner
+
+import "sync"
+
+// rampGain 自动模式下，并发数翻倍后总吞吐量至少增长该比例才继续增加并发
+const rampGain = 1.1
+
+// bandwidthBudget 控制并发测速占用的总带宽
+// 指定链路容量时，每个测速开始前按"预期速率"预留带宽，预留总和不超过容量时才允许开始；
+// 未指定时从单个测速开始逐步翻倍并发数，直到各测速速率之和不再增长，
+// 避免多个下载互相挤占导致测速结果偏低。
+type bandwidthBudget struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	capacity float64 // 用户指定的链路容量 (Mbps)，0 表示自动
+	minRate  float64 // 尚无测速结果时使用的预期速率
+	reserved float64 // 当前已预留的带宽
+	active   map[*bandwidthTicket]struct{}
+	sum      float64 // 已完成测速的速率之和，用于估算预期速率
+	count    int
+
+	// 自动模式
+	limit   int               // 当前允许的并发数
+	prev    int               // 翻倍前的并发数，0 表示尚未翻倍
+	growing bool              // 总吞吐量是否仍随并发数增长
+	levels  map[int]*rateStat // 按测速期间的最大并发数统计的总吞吐量
+}
+
+// rateStat 某个并发数下估算的总吞吐量
+type rateStat struct {
+	sum   float64
+	count int
+}
+
+func (s *rateStat) avg() float64 { return s.sum / float64(s.count) }
+
+// bandwidthTicket 一次测速的带宽预留
+type bandwidthTicket struct {
+	rate float64
+	peak int // 测速期间同时进行的测速数的最大值（含自身）
+}
+
+// newBandwidthBudget 创建带宽预算，capacity 为 0 时自动增加并发数直到总吞吐量饱和
+func newBandwidthBudget(capacity float64, minRate float64) *bandwidthBudget {
+	b := &bandwidthBudget{
+		capacity: capacity,
+		minRate:  minRate,
+		active:   make(map[*bandwidthTicket]struct{}),
+		limit:    1,
+		growing:  true,
+		levels:   make(map[int]*rateStat),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// expectedRate 估算下一个测速的速率：已完成测速的平均值，没有结果时用最低速度
+func (b *bandwidthBudget) expectedRate() float64 {
+	if b.count > 0 {
+		return b.sum / float64(b.count)
+	}
+	return b.minRate
+}
+
+// full 判断是否需要等待其他测速结束才能开始新的测速
+func (b *bandwidthBudget) full(rate float64) bool {
+	if b.capacity <= 0 {
+		return len(b.active) >= b.limit
+	}
+	return b.reserved+rate > b.capacity
+}
+
+// acquire 阻塞直到预算允许开始一次新的测速
+// 没有测速在进行时总是放行，保证不会死锁
+func (b *bandwidthBudget) acquire() *bandwidthTicket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rate := b.expectedRate()
+	for len(b.active) > 0 && b.full(rate) {
+		b.cond.Wait()
+		rate = b.expectedRate()
+	}
+
+	t := &bandwidthTicket{rate: rate}
+	b.active[t] = struct{}{}
+	for other := range b.active {
+		other.peak = max(other.peak, len(b.active))
+	}
+	b.reserved += rate
+	return t
+}
+
+// release 归还预留的带宽，measured 为本次测得的速率（失败时为 0）
+// 自动模式下用测得的速率估算该并发数下的总吞吐量，决定是否继续增加并发
+func (b *bandwidthBudget) release(t *bandwidthTicket, measured float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.active, t)
+	b.reserved -= t.rate
+	if measured > 0 {
+		b.sum += measured
+		b.count++
+		if b.capacity <= 0 {
+			b.ramp(t.peak, measured)
+		}
+	}
+	b.cond.Broadcast()
+}
+
+// ramp 记录 peak 个测速同时进行时的总吞吐量（按各测速速率相近估算为 measured×peak）
+// 当前并发数下的总吞吐量比翻倍前增长超过 rampGain 时再翻倍，否则停止增长；
+// 增加并发反而让总吞吐量下降时退回翻倍前的并发数
+func (b *bandwidthBudget) ramp(peak int, measured float64) {
+	stat := b.levels[peak]
+	if stat == nil {
+		stat = &rateStat{}
+		b.levels[peak] = stat
+	}
+	stat.sum += measured * float64(peak)
+	stat.count++
+
+	if !b.growing || peak != b.limit {
+		return
+	}
+	prev := b.levels[b.prev]
+	switch {
+	case prev == nil || stat.avg() >= prev.avg()*rampGain:
+		b.prev = b.limit
+		b.limit *= 2
+	case stat.avg() < prev.avg():
+		b.growing = false
+		b.limit = b.prev
+	default:
+		b.growing = false
+	}
+}
@@ -0,0 +1,71 @@
+package scanner
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runBudget 用 workers 个工人通过 b 完成 tests 次模拟测速，返回同时进行的测速数的最大值
+// rate 根据本次测速期间的并发数返回测得的速率
+func runBudget(b *bandwidthBudget, workers, tests int, rate func(peak int) float64) int {
+	var running, peak atomic.Int32
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				t := b.acquire()
+				n := running.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				running.Add(-1)
+
+				b.mu.Lock()
+				p := t.peak
+				b.mu.Unlock()
+				b.release(t, rate(p))
+			}
+		}()
+	}
+	for i := 0; i < tests; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	return int(peak.Load())
+}
+
+func TestBandwidthBudgetAutoRampsUp(t *testing.T) {
+	// 各测速互不影响（受服务端限速），总吞吐量随并发线性增长
+	b := newBandwidthBudget(0, 10)
+	peak := runBudget(b, 8, 16, func(int) float64 { return 50 })
+	if peak != 8 {
+		t.Fatalf("最大并发数 = %d，期望 8", peak)
+	}
+}
+
+func TestBandwidthBudgetAutoStopsAtSaturation(t *testing.T) {
+	// 链路只有 100 Mbps，并发测速平分带宽
+	b := newBandwidthBudget(0, 10)
+	peak := runBudget(b, 8, 16, func(peak int) float64 { return 100 / float64(peak) })
+	if peak > 2 {
+		t.Fatalf("最大并发数 = %d，链路饱和后不应继续增加", peak)
+	}
+}
+
+func TestBandwidthBudgetFixedCapacity(t *testing.T) {
+	b := newBandwidthBudget(100, 50)
+	peak := runBudget(b, 8, 16, func(int) float64 { return 50 })
+	if peak != 2 {
+		t.Fatalf("最大并发数 = %d，期望 2", peak)
+	}
+}
@@ -0,0 +1,152 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointInterval RunScanPool 写入断点的间隔
+const checkpointInterval = 10 * time.Second
+
+// Checkpoint 扫描断点：抽样计划、已探测的 IP 和已找到的结果
+// Seed 为抽样使用的随机种子，用同一种子可以重建同一份抽样计划
+type Checkpoint struct {
+	Seed    int64          `json:"seed"`
+	Plan    [][]string     `json:"plan"`
+	Done    []string       `json:"done"`
+	Results []ResultDetail `json:"results"`
+	Updated time.Time      `json:"updated"`
+
+	path string
+	mu   sync.Mutex
+	done map[string]bool
+}
+
+// NewCheckpoint 为新的扫描创建断点，RunScanPool 会定期写入 path
+func NewCheckpoint(path string, seed int64, plan [][]string) *Checkpoint {
+	return &Checkpoint{Seed: seed, Plan: plan, path: path, done: make(map[string]bool)}
+}
+
+// LoadCheckpoint 读取断点文件，之后的断点仍写回该文件
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(content, cp); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	cp.path = path
+	cp.done = make(map[string]bool, len(cp.Done))
+	for _, ip := range cp.Done {
+		cp.done[ip] = true
+	}
+	// 断点中只保存成功的结果
+	for i := range cp.Results {
+		cp.Results[i].isSuccess = true
+	}
+	return cp, nil
+}
+
+// Path 返回断点文件路径
+func (cp *Checkpoint) Path() string {
+	return cp.path
+}
+
+// Total 返回抽样计划中的 IP 总数
+func (cp *Checkpoint) Total() int {
+	total := 0
+	for _, group := range cp.Plan {
+		total += len(group)
+	}
+	return total
+}
+
+// remaining 返回计划中尚未探测的 IP
+func (cp *Checkpoint) remaining() ([][]string, int) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	var groups [][]string
+	total := 0
+	for _, group := range cp.Plan {
+		var left []string
+		for _, ip := range group {
+			if !cp.done[ip] {
+				left = append(left, ip)
+			}
+		}
+		groups = append(groups, left)
+		total += len(left)
+	}
+	return groups, total
+}
+
+// results 返回断点中已有结果的副本
+func (cp *Checkpoint) results() []FinalResult {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	results := make([]FinalResult, len(cp.Results))
+	for i, r := range cp.Results {
+		results[i] = FinalResult(r)
+	}
+	return results
+}
+
+// mark 记录一个已探测的地址
+func (cp *Checkpoint) mark(res FinalResult) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.done[res.Addr()] = true
+	cp.Done = append(cp.Done, res.Addr())
+	if res.isSuccess {
+		cp.Results = append(cp.Results, ResultDetail(res))
+	}
+}
+
+// Save 以原子方式写入断点文件（临时文件 + 重命名）
+func (cp *Checkpoint) Save() error {
+	cp.mu.Lock()
+	cp.Updated = time.Now()
+	content, err := json.Marshal(cp)
+	cp.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cp.path), "."+filepath.Base(cp.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// 断点用于应对崩溃和重启，必须落盘后再替换
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cp.path)
+}
+
+// Remove 扫描完成后删除断点文件
+func (cp *Checkpoint) Remove() error {
+	err := os.Remove(cp.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -9,64 +9,122 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/schollz/progressbar/v3"
 )
 
-// ScanIP 对指定 IP 进行探测
-func ScanIP(ip string, domain string, timeout time.Duration, latency int64) FinalResult {
-	// 提取纯域名用于 SNI
-	sni := domain
-	if strings.HasPrefix(sni, "http") {
-		u, _ := url.Parse(sni)
-		sni = u.Host
-	} else if idx := strings.Index(sni, "/"); idx != -1 {
-		sni = sni[:idx]
+// userAgent 所有 HTTP 请求使用的浏览器 User-Agent
+const userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// ScanIP 对指定 IP 进行多轮探测，统计丢包率与延迟分布，ctx 取消时立即放弃
+// ip 可以带端口（格式见 SplitAddr），结果中的 IP 和 Port 分开记录
+// 探测方式由 opts.Prober 决定，未指定时使用 TCP + TLS 握手（Scheme 为 http 时发送 HTTP 请求）
+func ScanIP(ctx context.Context, ip string, opts ScanOptions) FinalResult {
+	host, port, err := SplitAddr(ip)
+	if err != nil {
+		return FinalResult{IP: ip, isSuccess: false}
 	}
+	failed := FinalResult{IP: host, Port: port, isSuccess: false}
 
-	network := "tcp"
-	if strings.Contains(ip, ":") {
-		network = "tcp6"
+	prober := opts.Prober
+	if prober == nil {
+		// 明文 HTTP 没有 TLS 握手，改为发送 HTTP 请求并等待响应头
+		if opts.Scheme == "http" {
+			prober = NewHTTPProber(opts)
+		} else {
+			prober = NewTLSProber(opts)
+		}
 	}
 
-	start := time.Now()
+	rounds := opts.Rounds
+	if rounds < 1 {
+		rounds = 1
+	}
 
-	// TCP 拨号测试
-	conn, err := net.DialTimeout(network, net.JoinHostPort(ip, "443"), timeout)
-	if err != nil {
-		// 如果失败，返回 IP，但标记 isSuccess 为 false
-		return FinalResult{IP: ip, isSuccess: false}
+	// 设置了 colo 过滤时必须获取 trace
+	needTrace := opts.Trace || len(opts.KeepColos) > 0 || len(opts.ExcludeColos) > 0
+
+	var samples []time.Duration
+	var trace map[string]string
+	var alpn string
+	for i := 0; i < rounds; i++ {
+		// 只在第一次成功的连接上请求 /cdn-cgi/trace
+		probe, err := prober.Probe(ctx, ip, needTrace && trace == nil)
+		if ctx.Err() != nil {
+			// 用户中断，本 IP 的统计不完整，直接放弃
+			return failed
+		}
+		if err == nil {
+			samples = append(samples, probe.Latency)
+			if probe.Trace != nil {
+				trace = probe.Trace
+			}
+			if probe.ALPN != "" {
+				alpn = probe.ALPN
+			}
+		}
+	}
+	// 一轮都没成功，返回 IP，但标记 isSuccess 为 false
+	if len(samples) == 0 {
+		return failed
 	}
-	defer conn.Close()
 
-	// TLS 握手测试
-	tlsConn := tls.Client(conn, &tls.Config{
-		ServerName:         sni,
-		InsecureSkipVerify: true,
-	})
+	res := FinalResult{IP: host, Port: port, Scheme: schemeOf(opts.Scheme), ALPN: alpn}
+	applyLatencyStats(&res, samples, rounds)
+	if trace != nil {
+		res.Colo = trace["colo"]
+		res.Loc = trace["loc"]
+		res.HTTPVersion = trace["http"]
+		res.TLSVersion = trace["tls"]
+	}
 
-	tlsConn.SetDeadline(time.Now().Add(timeout))
-	err = tlsConn.Handshake()
-	if err != nil {
-		return FinalResult{IP: ip, isSuccess: false}
+	// 按数据中心过滤，未取到 colo 时无法判断，一并丢弃
+	if !coloAllowed(res.Colo, opts.KeepColos, opts.ExcludeColos) {
+		return failed
+	}
+
+	// 平均延迟超过 latency 或丢包率超过上限不返回
+	if res.RawLatency > opts.LatencyLimit || res.LossRate > opts.MaxLoss {
+		return failed
 	}
 
-	// 计算延迟
-	duration := time.Since(start)
+	res.isSuccess = true
+	return res
+}
 
-	// 延迟超过 latency 不返回
-	if duration.Milliseconds() > latency {
-		return FinalResult{IP: ip, isSuccess: false}
+// schemeOf 返回实际使用的协议，未指定时为 https
+func schemeOf(scheme string) string {
+	if scheme == "" {
+		return "https"
 	}
+	return scheme
+}
 
-	return FinalResult{
-		IP:         ip,
-		Latency:    fmt.Sprintf("%dms", duration.Milliseconds()),
-		RawLatency: duration.Milliseconds(), // 存入纯数字
-		isSuccess:  true,
+// speedURL 返回测速地址，scheme 为 http 时以明文 HTTP 下载
+// 地址本身带了协议头时以地址为准
+func speedURL(scheme string, domain string) string {
+	if strings.HasPrefix(domain, "http://") || strings.HasPrefix(domain, "https://") {
+		return domain
 	}
+	return schemeOf(scheme) + "://" + domain
+}
+
+// domain2SNI 从测速地址中提取纯域名
+func domain2SNI(domain string) string {
+	sni := domain
+	if strings.HasPrefix(sni, "http") {
+		u, _ := url.Parse(sni)
+		sni = u.Host
+	} else if idx := strings.Index(sni, "/"); idx != -1 {
+		sni = sni[:idx]
+	}
+	return sni
 }
 
 // SpeedResult 存储测速结果
@@ -75,8 +133,103 @@ type SpeedResult struct {
 	Speed float64 // 单位: Mbps
 }
 
-// TestSpeed 对指定 IP 进行下载测速
-func TestSpeed(ip string, domain string, timeout time.Duration) (float64, error) {
+// TestSpeed 对指定 IP 进行下载测速，ip 可以带端口，ctx 取消时中止测速并返回错误
+// domain 带 http:// 前缀时以明文 HTTP 下载，不带协议头时使用 https
+func TestSpeed(ctx context.Context, ip string, domain string, timeout time.Duration) (float64, error) {
+	speed, _, err := testSpeed(ctx, ip, domain, timeout, "", false)
+	return speed, err
+}
+
+// TestSpeedVersion 同 TestSpeed，以指定的 HTTP 版本（1.1 / 2 / 3）下载，服务端未使用该版本时返回错误
+func TestSpeedVersion(ctx context.Context, ip string, domain string, timeout time.Duration, version string) (float64, error) {
+	speed, _, err := testSpeed(ctx, ip, domain, timeout, version, false)
+	return speed, err
+}
+
+// testSpeed 是 TestSpeed 的实现，quiet 为 true 时不显示进度条（并发测速时避免输出错乱）
+// 第二个返回值为每 sampleInterval 的吞吐量采样 (Mbps)
+func testSpeed(ctx context.Context, ip string, domain string, timeout time.Duration, version string, quiet bool) (float64, []float64, error) {
+	client, host := newSpeedClient(ip, domain, timeout, version)
+	defer client.CloseIdleConnections()
+	bar := newSpeedBar(quiet)
+
+	downloadedBytes, actualDuration, samples, err := downloadStream(ctx, client, domain, host, timeout, version, bar)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// 测速完成后，清理掉那个斜杠，保持界面整洁
+	bar.Describe("Done")
+	bar.Finish()
+
+	// 使用真正下载所耗费的时间来计算，这样结果最准
+	if !quiet {
+		fmt.Printf("下载耗费时间: %.2f 秒 ", actualDuration.Seconds())
+	}
+	speed, err := toMbps(downloadedBytes, actualDuration)
+	return speed, samples, err
+}
+
+// TestSpeedParallel 对同一个 IP 同时打开 conns 条连接下载，返回各连接速度之和
+// 只要有一条连接测速成功就返回结果，全部失败时返回最后一个错误
+func TestSpeedParallel(ctx context.Context, ip string, domain string, timeout time.Duration, conns int) (float64, error) {
+	return testSpeedParallel(ctx, ip, domain, timeout, conns, "", false)
+}
+
+// testSpeedParallel 是 TestSpeedParallel 的实现，version 和 quiet 含义同 testSpeed
+// HTTP/2 和 HTTP/3 会在一条连接上复用多个请求，因此每条连接使用独立的客户端
+func testSpeedParallel(ctx context.Context, ip string, domain string, timeout time.Duration, conns int, version string, quiet bool) (float64, error) {
+	bar := newSpeedBar(quiet)
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		aggregate float64
+		ok        int
+		lastErr   error
+	)
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, host := newSpeedClient(ip, domain, timeout, version)
+			defer client.CloseIdleConnections()
+			n, d, _, err := downloadStream(ctx, client, domain, host, timeout, version, bar)
+			if err == nil {
+				var mbps float64
+				if mbps, err = toMbps(n, d); err == nil {
+					mu.Lock()
+					aggregate += mbps
+					ok++
+					mu.Unlock()
+					return
+				}
+			}
+			mu.Lock()
+			lastErr = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	bar.Describe("Done")
+	bar.Finish()
+
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	if ok == 0 {
+		return 0, lastErr
+	}
+	if !quiet {
+		fmt.Printf("并发连接: %d/%d ", ok, conns)
+	}
+	return aggregate, nil
+}
+
+// newSpeedClient 创建一个所有连接都指向指定 IP 的 HTTP 客户端，并返回用于 SNI / Host 的纯域名
+// version 为 2 时通过 ALPN 协商 HTTP/2，为 3 时通过 QUIC 使用 HTTP/3，其他取值使用 HTTP/1.1
+func newSpeedClient(ip string, domain string, timeout time.Duration, version string) (*http.Client, string) {
 	// 修正 domain 参数
 	// 去掉 https:// 或 http:// 协议头
 	cleanDomain := strings.TrimPrefix(domain, "https://")
@@ -87,6 +240,17 @@ func TestSpeed(ip string, domain string, timeout time.Duration) (float64, error)
 		cleanDomain = cleanDomain[:idx]
 	}
 
+	if version == "3" {
+		transport := &http3.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: cleanDomain},
+			// 同样强制将连接指向指定的测速 IP（UDP 端口与 TCP 相同）
+			Dial: func(ctx context.Context, _ string, tlsConf *tls.Config, _ *quic.Config) (*quic.Conn, error) {
+				return dialQUIC(ctx, ip, cleanDomain, tlsConf)
+			},
+		}
+		return &http.Client{Transport: transport, Timeout: timeout + 5*time.Second}, cleanDomain
+	}
+
 	// 创建一个自定义的传输层
 	transport := &http.Transport{
 		TLSClientConfig: &tls.Config{
@@ -95,44 +259,74 @@ func TestSpeed(ip string, domain string, timeout time.Duration) (float64, error)
 			// 记得带上 SNI
 			ServerName: cleanDomain,
 		},
-		// 核心逻辑：强制将所有连接指向指定的测速 IP
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// 核心逻辑：强制将所有连接指向指定的测速 IP（和端口）
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			network, addr, err := dialAddr(ip)
+			if err != nil {
+				return nil, err
+			}
 			dialer := &net.Dialer{Timeout: 5 * time.Second}
-			return dialer.DialContext(ctx, network, net.JoinHostPort(ip, "443"))
+			return dialer.DialContext(ctx, network, addr)
 		},
-		ForceAttemptHTTP2: false, // 开启 HTTP/2 提高性能
+		ForceAttemptHTTP2: version == "2", // 默认保持 HTTP/1.1，并发请求时每个请求独占一条连接
 	}
 
 	client := &http.Client{
 		Transport: transport,
 		Timeout:   timeout + 5*time.Second, // 测速超时稍长一点
 	}
+	return client, cleanDomain
+}
+
+// newSpeedBar 创建测速用的进度条，quiet 为 true 时输出被丢弃
+func newSpeedBar(quiet bool) *progressbar.ProgressBar {
+	var w io.Writer = os.Stdout // 改用 Stdout 试试
+	if quiet {
+		w = io.Discard
+	}
+	return progressbar.NewOptions(-1,
+		progressbar.OptionSetDescription(" \t"),
+		progressbar.OptionSetWriter(w),
+		progressbar.OptionShowBytes(false), // 关闭字节显示
+		progressbar.OptionSetWidth(20),
+		progressbar.OptionSetPredictTime(false), // 关闭剩余时间预测
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionClearOnFinish(), // 完成后清理，保持界面整洁
+	)
+}
 
+// downloadStream 在一条连接上下载 timeout 时长，返回下载字节数、从首字节开始的耗时和吞吐量采样
+// version 为 2 或 3 时，服务端实际使用的 HTTP 版本不符（如回退到 HTTP/1.1）视为测速失败
+func downloadStream(ctx context.Context, client *http.Client, domain string, host string, timeout time.Duration, version string, bar *progressbar.ProgressBar) (int64, time.Duration, []float64, error) {
 	// 构造下载请求
 	// 建议在服务器上放一个 10MB 的测试文件，如果没有，可以暂时请求主页
-	url := fmt.Sprintf("https://%s", domain)
-	req, _ := http.NewRequest("GET", url, nil)
+	req, _ := http.NewRequest("GET", speedURL("", domain), nil)
 	// 必须手动指定 Host，这要和你的域名完全一致
-	req.Host = cleanDomain
+	req.Host = host
 	// 补齐模拟浏览器的头部
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("User-Agent", userAgent)
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
 	// 使用 Context 实现“采样时间一到立即切断”
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	sampleCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	req = req.WithContext(ctx)
+	req = req.WithContext(sampleCtx)
 
 	resp, err := client.Do(req)
 	if err != nil {
 		// 情况 A：连接阶段就超时了，或者网络根本不通
 		// 此时 resp 是 nil，直接返回 0，不需要 Close
-		return 0, err
+		return 0, 0, nil, err
 	}
 
 	defer resp.Body.Close()
 
+	if err := checkProto(resp, version); err != nil {
+		return 0, 0, nil, err
+	}
+
 	// 设置一个标记，用于判断是否已经成功接收到首字节
 	firstByteReceived := make(chan struct{})
+	firstByteTimeout := make(chan struct{})
 
 	// 启动定时器监控首字节
 	go func() {
@@ -142,28 +336,19 @@ func TestSpeed(ip string, domain string, timeout time.Duration) (float64, error)
 			return
 		case <-time.After(2 * time.Second):
 			// 2秒内没收到首字节，强行关闭，触发 Read 报错
-			fmt.Printf("\n[IP: %s] 首字节超时，跳过\n", ip)
+			// 不在这里输出，由调用方决定是否显示（并发测速时避免输出错乱）
+			close(firstByteTimeout)
 			resp.Body.Close()
 		}
 	}()
 
-	// 读取内容并计算字节数
-	bar := progressbar.NewOptions(-1,
-		progressbar.OptionSetDescription(" \t"),
-		progressbar.OptionSetWriter(os.Stdout), // 改用 Stdout 试试
-		progressbar.OptionShowBytes(false),     // 关闭字节显示
-		progressbar.OptionSetWidth(20),
-		progressbar.OptionSetPredictTime(false), // 关闭剩余时间预测
-		progressbar.OptionEnableColorCodes(true),
-		progressbar.OptionClearOnFinish(), // 完成后清理，保持界面整洁
-	)
-
 	// 核心：在规定时间内读取数据
 	// 我们手动处理读取过程，计算读取了多少字节
 	var downloadedBytes int64
-	buffer := make([]byte, 64*1024) // 32KB 缓冲区
+	buffer := make([]byte, 64*1024) // 64KB 缓冲区
 	// 记录真正开始下载的时间（排除握手时间）
 	var downloadStart time.Time
+	var sampler *throughputSampler
 	firstByte := true
 
 	for {
@@ -171,38 +356,58 @@ func TestSpeed(ip string, domain string, timeout time.Duration) (float64, error)
 		if firstByte && n > 0 {
 			close(firstByteReceived)   // 核心：通知上面的协程，我们拿到数据了！
 			downloadStart = time.Now() // 记录收到第一个字节的时间
+			sampler = newThroughputSampler(downloadStart)
 			firstByte = false
 		}
 
 		if n > 0 {
 			downloadedBytes += int64(n)
 			bar.Add(n)
+			sampler.add(n, time.Now())
 		}
 
 		if readErr != nil {
-			// 情况 B：读取过程中时间到了（context deadline exceeded）
+			// 用户中断：本次测速不完整，直接放弃
+			if ctx.Err() != nil {
+				return 0, 0, nil, ctx.Err()
+			}
+			select {
+			case <-firstByteTimeout:
+				return 0, 0, nil, fmt.Errorf("首字节超时")
+			default:
+			}
+			// 情况 B：读取过程中时间到了（context deadline exceeded，HTTP/3 下为请求被取消）
 			// 这是正常的，我们跳出循环去计算已经下载了多少
-			//fmt.Println(readErr.Error())
-			if readErr == io.EOF || strings.Contains(readErr.Error(), "context deadline exceeded") {
+			if readErr == io.EOF || sampleCtx.Err() != nil || strings.Contains(readErr.Error(), "context deadline exceeded") {
 				break
 			}
 			// 如果是其他真实的读取错误，才返回 error
-			return 0, readErr
+			return 0, 0, nil, readErr
 		}
 	}
 
-	// 测速完成后，清理掉那个斜杠，保持界面整洁
-	bar.Describe("Done")
-	bar.Finish()
+	if firstByte {
+		return 0, 0, nil, fmt.Errorf("测速数据不足")
+	}
+	end := time.Now()
+	return downloadedBytes, end.Sub(downloadStart), sampler.finish(end), nil
+}
 
-	// 使用真正下载所耗费的时间来计算，这样结果最准
-	actualDuration := time.Since(downloadStart).Seconds()
-	fmt.Printf("下载耗费时间: %.2f 秒 ", actualDuration)
+// checkProto 检查服务端实际使用的 HTTP 版本，version 为 2 或 3 时不符（如回退到 HTTP/1.1）返回错误
+func checkProto(resp *http.Response, version string) error {
+	if (version == "2" || version == "3") && strconv.Itoa(resp.ProtoMajor) != version {
+		return fmt.Errorf("服务端未使用 HTTP/%s（实际为 %s）", version, resp.Proto)
+	}
+	return nil
+}
+
+// toMbps 将下载字节数与耗时换算为 Mbps
+func toMbps(downloadedBytes int64, duration time.Duration) (float64, error) {
+	actualDuration := duration.Seconds()
 	if actualDuration <= 0 || downloadedBytes == 0 {
 		return 0, fmt.Errorf("测速数据不足")
 	}
 
 	// 公式：字节 * 8 / 1024 / 1024 / 秒
-	mbps := (float64(downloadedBytes) * 8) / (1024 * 1024) / actualDuration
-	return mbps, nil
+	return (float64(downloadedBytes) * 8) / (1024 * 1024) / actualDuration, nil
 }
@@ -0,0 +1,123 @@
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testHandler 模拟 Cloudflare 的 /cdn-cgi/trace 和下载地址
+// trace 中的 http 字段为服务端实际使用的 HTTP 版本
+func testHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cdn-cgi/trace", func(w http.ResponseWriter, r *http.Request) {
+		proto := "http/1.1"
+		switch r.ProtoMajor {
+		case 2:
+			proto = "http/2"
+		case 3:
+			proto = "http/3"
+		}
+		w.Write([]byte("fl=1\ncolo=HKG\nloc=HK\nhttp=" + proto + "\ntls=TLSv1.3\n"))
+	})
+	mux.HandleFunc("/__down", func(w http.ResponseWriter, r *http.Request) {
+		chunk := make([]byte, 32*1024)
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	})
+	return mux
+}
+
+// newH2Server 启动同时支持 h2 和 http/1.1 的 TLS 测试服务器
+func newH2Server(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(testHandler())
+	srv.EnableHTTP2 = true
+	srv.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newH1Server 启动只支持 http/1.1 的 TLS 测试服务器
+func newH1Server(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(testHandler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// serverAddr 返回测试服务器的 "IP:端口"，即扫描时使用的地址格式
+func serverAddr(srv *httptest.Server) string {
+	return srv.Listener.Addr().String()
+}
+
+func TestSpeedHTTP2(t *testing.T) {
+	srv := newH2Server(t)
+
+	for _, version := range []string{"1.1", "2"} {
+		speed, samples, err := testSpeed(context.Background(), serverAddr(srv), "example.com/__down", 600*time.Millisecond, version, true)
+		if err != nil {
+			t.Fatalf("HTTP/%s 测速失败: %v", version, err)
+		}
+		if speed <= 0 || len(samples) == 0 {
+			t.Errorf("HTTP/%s 测速结果 %.2f Mbps，采样 %d 个", version, speed, len(samples))
+		}
+	}
+}
+
+func TestSpeedRejectsHTTP1Fallback(t *testing.T) {
+	srv := newH1Server(t)
+
+	_, _, err := testSpeed(context.Background(), serverAddr(srv), "example.com/__down", 600*time.Millisecond, "2", true)
+	if err == nil || !strings.Contains(err.Error(), "HTTP/2") {
+		t.Fatalf("服务端回退到 HTTP/1.1 时应测速失败，实际错误: %v", err)
+	}
+}
+
+func TestCheckProto(t *testing.T) {
+	tests := []struct {
+		version string
+		major   int
+		ok      bool
+	}{
+		{"", 1, true},
+		{"1.1", 2, true},
+		{"2", 2, true},
+		{"2", 1, false},
+		{"3", 3, true},
+		{"3", 2, false},
+		{"3", 1, false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{ProtoMajor: tt.major, Proto: "HTTP/" + strconv.Itoa(tt.major)}
+		if err := checkProto(resp, tt.version); (err == nil) != tt.ok {
+			t.Errorf("checkProto(HTTP/%d, %q) = %v", tt.major, tt.version, err)
+		}
+	}
+}
+
+func TestSpeedFirstByteTimeout(t *testing.T) {
+	// 只返回响应头，迟迟不发送数据
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	_, _, err := testSpeed(context.Background(), serverAddr(srv), "example.com/__down", 5*time.Second, "1.1", true)
+	if err == nil || !strings.Contains(err.Error(), "首字节超时") {
+		t.Fatalf("首字节超时应返回错误，实际为 %v", err)
+	}
+}
@@ -3,11 +3,120 @@ package scanner
 import "time"
 
 // 结构体定义，用于 JSON 和 CSV 导出
+// JSON 中只有地址和数据中心，完整的指标见 ResultDetail
 type FinalResult struct {
-	IP          string    `json:"address"`
-	Latency     string    `json:"-"` // 用于展示和 CSV 的字符串
-	DownloadMBs float64   `json:"-"` // 下载速度
-	RawLatency  int64     `json:"-"` // 内部排序用的数值 (ms)
-	isSuccess   bool      `json:"-"`
-	CreatedAt   time.Time `json:"-"` // 新增：记录测试时间
+	IP           string    `json:"address"`
+	Port         int       `json:"-"`              // 探测和测速使用的端口
+	Scheme       string    `json:"-"`              // 探测和测速使用的协议: https / http
+	Latency      string    `json:"-"`              // 用于展示和 CSV 的字符串（平均延迟）
+	DownloadMBs  float64   `json:"-"`              // 下载速度（单连接）
+	AggregateMBs float64   `json:"-"`              // 多连接并发下载的聚合速度，未开启时为 0
+	UploadMBs    float64   `json:"-"`              // 上传速度，未开启上传测速时为 0
+	SpeedSamples []float64 `json:"-"`              // 单连接下载每 250ms 的速率 (Mbps)
+	SpeedMedian  float64   `json:"-"`              // 采样速率的中位数
+	SpeedP10     float64   `json:"-"`              // 采样速率的 P10，反映最差时段的速度
+	SpeedCV      float64   `json:"-"`              // 采样速率的变异系数（标准差 / 平均值），越小越平稳
+	Stalls       int       `json:"-"`              // 卡顿次数，即连续低于中位数 30% 的时段数
+	RawLatency   int64     `json:"-"`              // 内部排序用的数值 (ms)，多轮探测时为平均延迟
+	MinLatency   int64     `json:"-"`              // 最小延迟 (ms)
+	MaxLatency   int64     `json:"-"`              // 最大延迟 (ms)
+	P95Latency   int64     `json:"-"`              // P95 延迟 (ms)
+	Jitter       float64   `json:"-"`              // 抖动，即延迟标准差 (ms)
+	LossRate     float64   `json:"-"`              // 丢包率 (%)，即握手失败轮次占比
+	Colo         string    `json:"colo,omitempty"` // Cloudflare 数据中心，来自 /cdn-cgi/trace
+	Loc          string    `json:"-"`              // trace 中的 loc（国家/地区）
+	HTTPVersion  string    `json:"-"`              // trace 中的 http（如 http/1.1）
+	TLSVersion   string    `json:"-"`              // trace 中的 tls（如 TLSv1.3）
+	ALPN         string    `json:"-"`              // 探测时 TLS / QUIC 握手协商的应用层协议（如 h2、http/1.1、h3）
+	WSLatency    int64     `json:"-"`              // WebSocket 升级耗时 (ms)，未做升级验证时为 0
+	Score        float64   `json:"-"`              // 综合得分，未配置 -score 时为 0
+	isSuccess    bool
+	CreatedAt    time.Time `json:"-"` // 新增：记录测试时间
+}
+
+// ResultDetail FinalResult 的完整 JSON 形式，包含全部指标，用于 HTTP API、断点和历史记录
+// 字段与 FinalResult 一一对应（只有标签不同），两者可以直接相互转换
+type ResultDetail struct {
+	IP           string    `json:"address"`
+	Port         int       `json:"port,omitempty"`
+	Scheme       string    `json:"scheme,omitempty"`
+	Latency      string    `json:"latency,omitempty"`
+	DownloadMBs  float64   `json:"download_mbps,omitempty"`
+	AggregateMBs float64   `json:"aggregate_mbps,omitempty"`
+	UploadMBs    float64   `json:"upload_mbps,omitempty"`
+	SpeedSamples []float64 `json:"speed_samples,omitempty"`
+	SpeedMedian  float64   `json:"speed_median_mbps,omitempty"`
+	SpeedP10     float64   `json:"speed_p10_mbps,omitempty"`
+	SpeedCV      float64   `json:"speed_cv,omitempty"`
+	Stalls       int       `json:"stalls,omitempty"`
+	RawLatency   int64     `json:"latency_ms"`
+	MinLatency   int64     `json:"min_latency_ms"`
+	MaxLatency   int64     `json:"max_latency_ms"`
+	P95Latency   int64     `json:"p95_latency_ms"`
+	Jitter       float64   `json:"jitter_ms"`
+	LossRate     float64   `json:"loss_percent"`
+	Colo         string    `json:"colo,omitempty"`
+	Loc          string    `json:"loc,omitempty"`
+	HTTPVersion  string    `json:"http,omitempty"`
+	TLSVersion   string    `json:"tls,omitempty"`
+	ALPN         string    `json:"alpn,omitempty"`
+	WSLatency    int64     `json:"ws_latency_ms,omitempty"`
+	Score        float64   `json:"score,omitempty"`
+	isSuccess    bool
+	CreatedAt    time.Time `json:"created_at,omitzero"`
+}
+
+// Success 返回探测是否成功（通过了延迟、丢包和数据中心过滤）
+func (r FinalResult) Success() bool {
+	return r.isSuccess
+}
+
+// ScanOptions 扫描阶段的探测参数
+type ScanOptions struct {
+	Domain       string        // SNI 域名（可带路径）
+	Scheme       string        // https（默认）或 http，http 时不做 TLS 握手
+	Timeout      time.Duration // 单轮探测超时
+	Prober       Prober        // 探测方式，nil 时使用 TCP + TLS 握手（Scheme 为 http 时发送 HTTP 请求）
+	LatencyLimit int64         // 平均延迟上限 (ms)
+	Rounds       int           // 每个 IP 的握手轮数
+	MaxLoss      float64       // 丢包率上限 (%)，超过则丢弃
+	SortBy       string        // 排序指标: latency / loss / jitter / p95
+	Scorer       *Scorer       // 综合得分，不为 nil 时按得分排序（SortBy 只用于得分相同时）
+	Trace        bool          // 握手后请求 /cdn-cgi/trace 获取 colo 等信息
+	KeepColos    []string      // 只保留这些数据中心（隐含 Trace）
+	ExcludeColos []string      // 排除这些数据中心（隐含 Trace）
+	Progress     *Progress     // 进度计数器，可为 nil
+
+	// OnResult 每个 IP 探测完成后调用（包括失败的 IP，中断时除外），会被多个协程并发调用，可为 nil
+	OnResult func(FinalResult)
+	// Checkpoint 不为 nil 时跳过其中已探测的 IP、带上已有结果，并定期写入断点文件
+	Checkpoint *Checkpoint
+	// Reputation 返回 IP 的历史信誉分 (0~1，0.5 为无记录)，作为综合得分中的 history，可为 nil
+	Reputation func(ip string) float64
+}
+
+// DeepTestOptions 下载测速阶段的参数
+type DeepTestOptions struct {
+	OutCount    int           // 最终结果数，最多测试 OutCount*2 个候选
+	Domain      string        // 测速地址（域名 + 路径）
+	Scheme      string        // https（默认）或 http，http 时以明文 HTTP 下载
+	HTTPVersion string        // 下载使用的 HTTP 版本: 1.1（默认）/ 2 / 3
+	MinSpeed    float64       // 单连接最低下载速度 (Mbps)
+	Duration    time.Duration // 每次测速的采样时长
+	Conns       int           // 并发连接数，大于 1 时额外测量聚合带宽
+	Upload      string        // 上传测速地址（如 speed.cloudflare.com/__up），为空不测上传
+	MinUpload   float64       // 最低上传速度 (Mbps)
+	SortBy      string        // 最终排序指标: download（默认）/ upload / total（上传 + 下载）/ stable（下载 × 稳定性）
+	Scorer      *Scorer       // 综合得分，不为 nil 时候选顺序和最终排序都按得分，SortBy 不再生效
+
+	Concurrency int     // 同时测速的 IP 数，默认 1（逐个测速）
+	LinkMbps    float64 // 链路总带宽 (Mbps)，并发测速的预期速率之和不超过它；0 表示根据单独测速的结果自动估算
+
+	Progress *Progress // 进度计数器，可为 nil
+
+	// OnResult 每个候选测速完成后调用，ok 表示是否达标，会被多个协程并发调用，可为 nil
+	OnResult func(res FinalResult, ok bool)
+	// Reputation 返回 IP 的历史信誉分 (0~1，0.5 为无记录)，不为 nil 时参与候选顺序和最终排序
+	// 设置了 Scorer 时只作为综合得分中的 history
+	Reputation func(ip string) float64
 }
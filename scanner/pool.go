@@ -11,16 +11,26 @@ import (
 )
 
 // RunScanPool 启动并发扫描
-func RunScanPool(ipGroups [][]string, workerCount int, domain string, latency int64, total int) []FinalResult {
+// ctx 取消后停止派发新任务，等待工人退出，并返回已经收集到的结果
+// 设置了 opts.Checkpoint 时从断点继续：只探测尚未探测的 IP，扫描完成后删除断点文件
+func RunScanPool(ctx context.Context, ipGroups [][]string, workerCount int, opts ScanOptions, total int) []FinalResult {
 	jobs := make(chan string, 200)
 	resultsChan := make(chan FinalResult, 200)
 	var wg sync.WaitGroup
 
+	cp := opts.Checkpoint
+	stopSaver := func() {}
+	if cp != nil {
+		ipGroups, total = cp.remaining()
+		stopSaver = startCheckpointSaver(cp)
+	}
+	opts.Progress.startScan(total)
+
 	// 定义旋转字符
 	var spinnerChars = []string{"\\", "|", "/", "-"}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	go startSpinner(ctx, spinnerChars) // 启动旋转图标
+	spinCtx, cancel := context.WithCancel(context.Background())
+	go startSpinner(spinCtx, spinnerChars) // 启动旋转图标
 
 	// 初始化进度条
 	bar := progressbar.NewOptions(total,
@@ -44,27 +54,43 @@ func RunScanPool(ipGroups [][]string, workerCount int, domain string, latency in
 			defer wg.Done()
 			for ip := range jobs {
 				// 调用同包下的 ScanIP
-				res := ScanIP(ip, domain, 2*time.Second, latency)
+				res := ScanIP(ctx, ip, opts)
 				if res.isSuccess {
 					resultsChan <- res
 				}
+				opts.Progress.addScan(res.isSuccess)
+				if ctx.Err() == nil {
+					if cp != nil {
+						cp.mark(res)
+					}
+					if opts.OnResult != nil {
+						opts.OnResult(res)
+					}
+				}
 				bar.Add(1)
 			}
 		}()
 	}
 
-	// 投放任务
+	// 投放任务，收到中断后不再派发
 	go func() {
+		defer close(jobs)
 		for _, group := range ipGroups {
 			for _, ip := range group {
-				jobs <- ip
+				select {
+				case jobs <- ip:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
-		close(jobs)
 	}()
 
-	// 收集结果
+	// 收集结果，从断点恢复时带上断点中已有的结果
 	var finalResults []FinalResult
+	if cp != nil {
+		finalResults = cp.results()
+	}
 	done := make(chan struct{})
 	go func() {
 		for r := range resultsChan {
@@ -79,14 +105,61 @@ func RunScanPool(ipGroups [][]string, workerCount int, domain string, latency in
 	close(resultsChan)
 	<-done // 等待结果切片填充完毕
 
-	// 按延迟排序
-	sort.Slice(finalResults, func(i, j int) bool {
-		return finalResults[i].RawLatency < finalResults[j].RawLatency
-	})
+	if ctx.Err() != nil {
+		fmt.Printf("\n扫描已中断，保留已完成的 %d 个结果\n", len(finalResults))
+	}
+	stopSaver()
+	if cp != nil {
+		finishCheckpoint(ctx, cp)
+	}
+
+	// 按指定指标排序（默认平均延迟），配置了综合得分时再按得分排序
+	SortResults(finalResults, opts.SortBy)
+	opts.Scorer.Rank(finalResults, opts.Reputation)
 
 	return finalResults
 }
 
+// startCheckpointSaver 每隔 checkpointInterval 写入一次断点，返回停止函数
+func startCheckpointSaver(cp *Checkpoint) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := cp.Save(); err != nil {
+					fmt.Printf("\n写入断点失败: %v\n", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// finishCheckpoint 扫描中断时写入最终断点，完成时删除断点文件
+func finishCheckpoint(ctx context.Context, cp *Checkpoint) {
+	if ctx.Err() != nil {
+		if err := cp.Save(); err != nil {
+			fmt.Printf("写入断点失败: %v\n", err)
+			return
+		}
+		fmt.Printf("断点已保存至 %s，可使用 -resume %s 继续扫描\n", cp.Path(), cp.Path())
+		return
+	}
+	if err := cp.Remove(); err != nil {
+		fmt.Printf("删除断点文件失败: %v\n", err)
+	}
+}
+
 func startSpinner(ctx context.Context, spinnerChars []string) {
 	i := 0
 	for {
@@ -103,41 +176,185 @@ func startSpinner(ctx context.Context, spinnerChars []string) {
 	}
 }
 
-func RunDeepTest(outCount int, domain string, minSpeed float64, finalResults []FinalResult) []FinalResult {
-	var finalSorted []FinalResult
-	outResults := 0
-	for i := 0; i < len(finalResults) && i < outCount*2; i++ {
-		bestIP := finalResults[i].IP
+// RunDeepTest 对候选 IP 进行下载测速，ctx 取消后停止测速并返回已测完的结果
+// Concurrency 大于 1 时由多个工人同时测速，并通过带宽预算避免并发下载互相挤占
+func RunDeepTest(ctx context.Context, opts DeepTestOptions, finalResults []FinalResult) []FinalResult {
+	candidates := finalResults
+	if opts.Scorer != nil {
+		// 按扫描指标和历史信誉计算的得分决定哪些候选进入测速
+		candidates = append([]FinalResult(nil), candidates...)
+		opts.Scorer.Rank(candidates, opts.Reputation)
+	} else if opts.Reputation != nil {
+		candidates = rankByReputation(candidates, opts.Reputation)
+	}
+	if len(candidates) > opts.OutCount*2 {
+		candidates = candidates[:opts.OutCount*2]
+	}
 
-		speed, err := TestSpeed(bestIP, domain, 5*time.Second)
+	workerCount := opts.Concurrency
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	quiet := workerCount > 1
+	budget := newBandwidthBudget(opts.LinkMbps, opts.MinSpeed)
+	opts.Progress.startDeep(len(candidates))
 
-		if err != nil {
-			fmt.Printf("测速异常: %v\n", err)
-			continue
-		} else if speed < minSpeed {
-			fmt.Printf("速率过低: [%s] 速度: %.2f Mbps\n", bestIP, speed)
-			continue
-		} else {
-			fmt.Printf("🚀 [%s] 速度: %.2f Mbps\n", bestIP, speed)
+	var (
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+		finalSorted []FinalResult
+	)
+	// enough 判断是否已经凑够 OutCount 个达标结果
+	enough := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(finalSorted) >= opts.OutCount
+	}
+
+	jobs := make(chan FinalResult)
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for candidate := range jobs {
+				if ctx.Err() != nil || enough() {
+					continue
+				}
+
+				var ticket *bandwidthTicket
+				if quiet {
+					ticket = budget.acquire()
+				}
+				res, ok := deepTestOne(ctx, candidate, opts, quiet)
+				if ticket != nil {
+					budget.release(ticket, max(res.DownloadMBs, res.AggregateMBs))
+				}
+				opts.Progress.addDeep(ok)
+				if opts.OnResult != nil && ctx.Err() == nil {
+					opts.OnResult(res, ok)
+				}
+
+				if ok {
+					mu.Lock()
+					finalSorted = append(finalSorted, res)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	// 投放任务，中断或凑够结果后不再派发
+	for _, candidate := range candidates {
+		if ctx.Err() != nil || enough() {
+			break
 		}
+		jobs <- candidate
+	}
+	close(jobs)
+	wg.Wait()
 
-		finalSorted = append(finalSorted, FinalResult{
-			IP:          bestIP,
-			DownloadMBs: speed,                   // 对应结构体中的 DownloadMBs 字段
-			Latency:     finalResults[i].Latency, // 别忘了把第一轮测得的延迟也带过来，方便存入 CSV
-			CreatedAt:   time.Now(),              // 记录这一刻的时间
-		})
+	if ctx.Err() != nil {
+		fmt.Printf("\n测速已中断，保留已完成的 %d 个结果\n", len(finalSorted))
+	}
 
-		outResults++
-		if outResults == outCount {
-			i = outCount * 2
+	// 按速度再次排序（stable 时按稳定性加权），有历史信誉时按信誉加权后的速度排序
+	speedOf := func(r FinalResult) float64 {
+		speed := r.DownloadMBs
+		switch opts.SortBy {
+		case "upload":
+			speed = r.UploadMBs
+		case "total":
+			speed = r.DownloadMBs + r.UploadMBs
+		case "stable":
+			speed = r.DownloadMBs * r.Stability()
+		}
+		if opts.Reputation == nil {
+			return speed
 		}
+		return speed * (0.5 + opts.Reputation(r.IP))
+	}
+	if opts.Scorer != nil {
+		// 带上测速结果重新计算综合得分
+		opts.Scorer.Rank(finalSorted, opts.Reputation)
+	} else {
+		sort.Slice(finalSorted, func(i, j int) bool {
+			return speedOf(finalSorted[i]) > speedOf(finalSorted[j])
+		})
 	}
 
-	// 按速度再次排序
-	sort.Slice(finalSorted, func(i, j int) bool {
-		return finalSorted[i].DownloadMBs > finalSorted[j].DownloadMBs
-	})
+	// 并发测速时可能有多个工人同时达标，只保留前 OutCount 个
+	if len(finalSorted) > opts.OutCount {
+		finalSorted = finalSorted[:opts.OutCount]
+	}
 
 	return finalSorted
 }
+
+// rankByReputation 按信誉加权后的延迟重新排列候选（不修改原切片）
+// 信誉 1 的 IP 延迟按一半计算，信誉 0 的按 1.5 倍计算，无记录 (0.5) 时不变
+func rankByReputation(candidates []FinalResult, reputation func(ip string) float64) []FinalResult {
+	weighted := make(map[string]float64, len(candidates))
+	for _, r := range candidates {
+		weighted[r.Addr()] = float64(r.RawLatency) * (1.5 - reputation(r.IP))
+	}
+
+	ranked := append([]FinalResult(nil), candidates...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return weighted[ranked[i].Addr()] < weighted[ranked[j].Addr()]
+	})
+	return ranked
+}
+
+// deepTestOne 对单个候选 IP 测速，返回带上速度的结果以及是否达标
+// 测速失败时返回的结果速度为 0
+func deepTestOne(ctx context.Context, candidate FinalResult, opts DeepTestOptions, quiet bool) (FinalResult, bool) {
+	bestIP := candidate.Addr()
+	domain := speedURL(opts.Scheme, opts.Domain)
+
+	speed, samples, err := testSpeed(ctx, bestIP, domain, opts.Duration, opts.HTTPVersion, quiet)
+	applyThroughputStats(&candidate, samples)
+
+	if err != nil {
+		fmt.Printf("测速异常: [%s] %v\n", bestIP, err)
+		return candidate, false
+	} else if speed < opts.MinSpeed {
+		fmt.Printf("速率过低: [%s] 速度: %.2f Mbps\n", bestIP, speed)
+		candidate.DownloadMBs = speed
+		return candidate, false
+	} else {
+		fmt.Printf("🚀 [%s] 速度: %.2f Mbps (中位数 %.2f / P10 %.2f / 波动 %.2f / 卡顿 %d 次)\n",
+			bestIP, speed, candidate.SpeedMedian, candidate.SpeedP10, candidate.SpeedCV, candidate.Stalls)
+	}
+
+	// 带上第一轮测得的延迟、丢包等指标，方便存入 CSV
+	res := candidate
+	res.DownloadMBs = speed    // 对应结构体中的 DownloadMBs 字段
+	res.CreatedAt = time.Now() // 记录这一刻的时间
+
+	// 多连接模式：单线程达标后再测一次并发聚合带宽
+	if opts.Conns > 1 {
+		aggregate, err := testSpeedParallel(ctx, bestIP, domain, opts.Duration, opts.Conns, opts.HTTPVersion, quiet)
+		if err != nil {
+			fmt.Printf("并发测速异常: [%s] %v\n", bestIP, err)
+		} else {
+			fmt.Printf("🚀 [%s] %d 连接聚合速度: %.2f Mbps\n", bestIP, opts.Conns, aggregate)
+			res.AggregateMBs = aggregate
+		}
+	}
+
+	// 下载达标后测上传，上传失败或低于 MinUpload 视为不达标
+	if opts.Upload != "" {
+		upload, err := testUpload(ctx, bestIP, speedURL(opts.Scheme, opts.Upload), opts.Duration, opts.HTTPVersion, quiet)
+		if err != nil {
+			fmt.Printf("上传测速异常: [%s] %v\n", bestIP, err)
+			return res, false
+		}
+		res.UploadMBs = upload
+		if upload < opts.MinUpload {
+			fmt.Printf("上传速率过低: [%s] 上传: %.2f Mbps\n", bestIP, upload)
+			return res, false
+		}
+		fmt.Printf("⬆️ [%s] 上传速度: %.2f Mbps\n", bestIP, upload)
+	}
+	return res, true
+}
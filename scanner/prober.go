@@ -0,0 +1,261 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prober 对单个 IP 完成一次探测
+// RunScanPool 会在多个协程中并发调用 Probe，实现必须是并发安全的
+type Prober interface {
+	// Probe 完成一次探测并返回耗时，ip 可以带端口（格式见 SplitAddr）
+	// withTrace 为 true 时，实现应尽量附带 /cdn-cgi/trace 的内容（不计入耗时），拿不到时 Trace 为 nil
+	Probe(ctx context.Context, ip string, withTrace bool) (ProbeResult, error)
+}
+
+// ProbeResult 单次探测的结果
+type ProbeResult struct {
+	Latency time.Duration     // 探测耗时
+	Trace   map[string]string // /cdn-cgi/trace 的内容，可能为 nil
+	ALPN    string            // TLS / QUIC 握手协商的应用层协议（如 h2、http/1.1、h3），未协商时为空
+}
+
+// ProberFactory 根据扫描参数创建 Prober
+type ProberFactory func(opts ScanOptions) Prober
+
+var (
+	probersMu sync.RWMutex
+	probers   = map[string]ProberFactory{
+		"tcp":   func(opts ScanOptions) Prober { return NewTCPProber(opts) },
+		"tls":   func(opts ScanOptions) Prober { return NewTLSProber(opts) },
+		"http":  func(opts ScanOptions) Prober { return NewHTTPProber(opts) },
+		"trace": func(opts ScanOptions) Prober { return NewTraceProber(opts) },
+		"quic":  func(opts ScanOptions) Prober { return NewQUICProber(opts) },
+	}
+)
+
+// RegisterProber 注册自定义探测方式，同名时覆盖已有的实现
+func RegisterProber(name string, factory ProberFactory) {
+	probersMu.Lock()
+	defer probersMu.Unlock()
+	probers[name] = factory
+}
+
+// NewProber 按名字创建探测器
+func NewProber(name string, opts ScanOptions) (Prober, error) {
+	probersMu.RLock()
+	factory, ok := probers[name]
+	probersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("未知的探测方式 %q，可选: %s", name, strings.Join(ProberNames(), "/"))
+	}
+	return factory(opts), nil
+}
+
+// ProberNames 返回已注册的探测方式名字（已排序）
+func ProberNames() []string {
+	probersMu.RLock()
+	defer probersMu.RUnlock()
+	names := make([]string, 0, len(probers))
+	for name := range probers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TCPProber 只测试 TCP 连接耗时，无法获取 trace
+type TCPProber struct {
+	Timeout time.Duration
+}
+
+// NewTCPProber 创建 TCP 连接探测器
+func NewTCPProber(opts ScanOptions) *TCPProber {
+	return &TCPProber{Timeout: opts.Timeout}
+}
+
+func (p *TCPProber) Probe(ctx context.Context, ip string, withTrace bool) (ProbeResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialTCP(ctx, ip)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	conn.Close()
+	return ProbeResult{Latency: time.Since(start)}, nil
+}
+
+// TLSProber 测试 TCP + TLS 握手耗时（默认探测方式），握手时同时提供 h2 和 http/1.1，记录服务端选择的协议
+type TLSProber struct {
+	SNI     string
+	Timeout time.Duration
+}
+
+// NewTLSProber 创建 TCP + TLS 握手探测器
+func NewTLSProber(opts ScanOptions) *TLSProber {
+	return &TLSProber{SNI: domain2SNI(opts.Domain), Timeout: opts.Timeout}
+}
+
+func (p *TLSProber) Probe(ctx context.Context, ip string, withTrace bool) (ProbeResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialTLS(ctx, ip, p.SNI, []string{"h2", "http/1.1"})
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	defer conn.Close()
+
+	// 计算延迟
+	res := ProbeResult{Latency: time.Since(start), ALPN: conn.ConnectionState().NegotiatedProtocol}
+
+	if withTrace {
+		// trace 请求单独计时，不占用握手的超时
+		conn.SetDeadline(time.Now().Add(p.Timeout))
+		res.Trace, _ = fetchTrace(conn, p.SNI)
+	}
+	return res, nil
+}
+
+// HTTPProber 在 TLS 连接上发送 HEAD 请求，测量到收到响应头为止的耗时
+// Plain 为 true 时（-scheme http）直接在 TCP 连接上发送明文请求
+type HTTPProber struct {
+	SNI     string
+	Timeout time.Duration
+	Plain   bool
+}
+
+// NewHTTPProber 创建 HTTP HEAD 探测器
+func NewHTTPProber(opts ScanOptions) *HTTPProber {
+	return &HTTPProber{SNI: domain2SNI(opts.Domain), Timeout: opts.Timeout, Plain: opts.Scheme == "http"}
+}
+
+func (p *HTTPProber) Probe(ctx context.Context, ip string, withTrace bool) (ProbeResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialHTTP(ctx, ip, p.SNI, p.Plain)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	defer conn.Close()
+
+	// HEAD 请求同样受 ctx 的超时约束
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	req, _ := http.NewRequest("HEAD", "https://"+p.SNI+"/", nil)
+	req.Header.Set("User-Agent", userAgent)
+	if err := req.Write(conn); err != nil {
+		return ProbeResult{}, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	resp.Body.Close()
+
+	res := ProbeResult{Latency: time.Since(start), ALPN: negotiatedProtocol(conn)}
+
+	if withTrace {
+		// 复用同一条 keep-alive 连接请求 trace
+		conn.SetDeadline(time.Now().Add(p.Timeout))
+		res.Trace, _ = fetchTrace(conn, p.SNI)
+	}
+	return res, nil
+}
+
+// TraceProber 在 TLS 连接上请求 /cdn-cgi/trace，耗时包含 trace 响应，且每次都返回 trace
+// trace 请求失败（如非 Cloudflare 节点）视为探测失败，Plain 含义同 HTTPProber
+type TraceProber struct {
+	SNI     string
+	Timeout time.Duration
+	Plain   bool
+}
+
+// NewTraceProber 创建 HTTP trace 探测器
+func NewTraceProber(opts ScanOptions) *TraceProber {
+	return &TraceProber{SNI: domain2SNI(opts.Domain), Timeout: opts.Timeout, Plain: opts.Scheme == "http"}
+}
+
+func (p *TraceProber) Probe(ctx context.Context, ip string, withTrace bool) (ProbeResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialHTTP(ctx, ip, p.SNI, p.Plain)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	trace, err := fetchTrace(conn, p.SNI)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	return ProbeResult{Latency: time.Since(start), Trace: trace, ALPN: negotiatedProtocol(conn)}, nil
+}
+
+// dialTCP 连接指定地址，未带端口时连接 443 端口
+func dialTCP(ctx context.Context, ip string) (net.Conn, error) {
+	network, addr, err := dialAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	// TCP 拨号测试
+	dialer := &net.Dialer{}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// dialHTTP 建立发送 HTTP/1.1 请求用的连接，plain 为 true 时只建立 TCP 连接，否则完成 TLS 握手
+func dialHTTP(ctx context.Context, ip string, sni string, plain bool) (net.Conn, error) {
+	if plain {
+		return dialTCP(ctx, ip)
+	}
+	return dialTLS(ctx, ip, sni, []string{"http/1.1"})
+}
+
+// negotiatedProtocol 返回 TLS 连接协商的应用层协议，明文连接返回空字符串
+func negotiatedProtocol(conn net.Conn) string {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState().NegotiatedProtocol
+	}
+	return ""
+}
+
+// dialTLS 连接指定 IP 并完成 TLS 握手，alpn 为握手时提供的应用层协议
+func dialTLS(ctx context.Context, ip string, sni string, alpn []string) (*tls.Conn, error) {
+	conn, err := dialTCP(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	// TLS 握手测试
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         sni,
+		InsecureSkipVerify: true,
+		NextProtos:         alpn,
+	})
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
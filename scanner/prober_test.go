@@ -0,0 +1,68 @@
+package scanner
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTLSProberALPN(t *testing.T) {
+	tests := []struct {
+		name  string
+		addr  string
+		alpn  string
+		proto string // trace 中服务端实际使用的 HTTP 版本
+	}{
+		{"h2", serverAddr(newH2Server(t)), "h2", "http/2"},
+		{"http/1.1", serverAddr(newH1Server(t)), "http/1.1", "http/1.1"},
+	}
+	for _, tt := range tests {
+		p := &TLSProber{SNI: "example.com", Timeout: 2 * time.Second}
+		res, err := p.Probe(context.Background(), tt.addr, true)
+		if err != nil {
+			t.Fatalf("%s: 探测失败: %v", tt.name, err)
+		}
+		if res.ALPN != tt.alpn {
+			t.Errorf("%s: ALPN = %q，期望 %q", tt.name, res.ALPN, tt.alpn)
+		}
+		if res.Trace["colo"] != "HKG" || res.Trace["http"] != tt.proto {
+			t.Errorf("%s: trace = %v", tt.name, res.Trace)
+		}
+	}
+}
+
+func TestHTTPProberUsesHTTP1(t *testing.T) {
+	p := &HTTPProber{SNI: "example.com", Timeout: 2 * time.Second}
+	res, err := p.Probe(context.Background(), serverAddr(newH2Server(t)), false)
+	if err != nil {
+		t.Fatalf("探测失败: %v", err)
+	}
+	if res.ALPN != "http/1.1" {
+		t.Errorf("ALPN = %q，HTTP 探测只应提供 http/1.1", res.ALPN)
+	}
+}
+
+func TestScanIPDefaultProber(t *testing.T) {
+	plain := httptest.NewServer(testHandler())
+	defer plain.Close()
+
+	tests := []struct {
+		scheme string
+		addr   string
+	}{
+		{"https", serverAddr(newH1Server(t))},
+		// 明文 HTTP 服务端不支持 TLS 握手，未指定 Prober 时应改用 HTTP 请求探测
+		{"http", plain.Listener.Addr().String()},
+	}
+	for _, tt := range tests {
+		opts := ScanOptions{Domain: "example.com", Scheme: tt.scheme, Timeout: 2 * time.Second, Rounds: 2, LatencyLimit: 2000, Trace: true}
+		res := ScanIP(context.Background(), tt.addr, opts)
+		if !res.Success() {
+			t.Fatalf("%s: 探测失败", tt.scheme)
+		}
+		if res.Scheme != tt.scheme || res.Colo != "HKG" {
+			t.Errorf("%s: 结果 = %+v", tt.scheme, res)
+		}
+	}
+}
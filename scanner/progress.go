@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Progress 扫描进度计数器，由 RunScanPool / RunDeepTest 更新，可被其他协程并发读取
+// 所有方法对 nil 接收者都是安全的，不需要统计进度时传 nil 即可
+type Progress struct {
+	scanTotal  atomic.Int64
+	scanDone   atomic.Int64
+	scanFound  atomic.Int64
+	deepTotal  atomic.Int64
+	deepDone   atomic.Int64
+	deepPassed atomic.Int64
+
+	mu    sync.RWMutex
+	stage string
+}
+
+// ProgressSnapshot 某一时刻的进度
+type ProgressSnapshot struct {
+	Stage      string `json:"stage"`
+	ScanTotal  int64  `json:"scan_total"`  // 本轮扫描的 IP 总数
+	ScanDone   int64  `json:"scan_done"`   // 已探测的 IP 数
+	ScanFound  int64  `json:"scan_found"`  // 探测成功的 IP 数
+	DeepTotal  int64  `json:"deep_total"`  // 待测速的候选数
+	DeepDone   int64  `json:"deep_done"`   // 已测速的候选数
+	DeepPassed int64  `json:"deep_passed"` // 测速达标的候选数
+}
+
+// SetStage 设置当前阶段名，如 scan / deeptest / idle
+func (p *Progress) SetStage(stage string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.stage = stage
+	p.mu.Unlock()
+}
+
+// Snapshot 读取当前进度
+func (p *Progress) Snapshot() ProgressSnapshot {
+	if p == nil {
+		return ProgressSnapshot{}
+	}
+	p.mu.RLock()
+	stage := p.stage
+	p.mu.RUnlock()
+	return ProgressSnapshot{
+		Stage:      stage,
+		ScanTotal:  p.scanTotal.Load(),
+		ScanDone:   p.scanDone.Load(),
+		ScanFound:  p.scanFound.Load(),
+		DeepTotal:  p.deepTotal.Load(),
+		DeepDone:   p.deepDone.Load(),
+		DeepPassed: p.deepPassed.Load(),
+	}
+}
+
+// startScan 开始扫描阶段，清零全部计数
+func (p *Progress) startScan(total int) {
+	if p == nil {
+		return
+	}
+	p.SetStage("scan")
+	p.scanTotal.Store(int64(total))
+	p.scanDone.Store(0)
+	p.scanFound.Store(0)
+	p.deepTotal.Store(0)
+	p.deepDone.Store(0)
+	p.deepPassed.Store(0)
+}
+
+// addScan 记录一个 IP 探测完成
+func (p *Progress) addScan(found bool) {
+	if p == nil {
+		return
+	}
+	p.scanDone.Add(1)
+	if found {
+		p.scanFound.Add(1)
+	}
+}
+
+// startDeep 开始测速阶段
+func (p *Progress) startDeep(total int) {
+	if p == nil {
+		return
+	}
+	p.SetStage("deeptest")
+	p.deepTotal.Store(int64(total))
+	p.deepDone.Store(0)
+	p.deepPassed.Store(0)
+}
+
+// addDeep 记录一个候选测速完成
+func (p *Progress) addDeep(passed bool) {
+	if p == nil {
+		return
+	}
+	p.deepDone.Add(1)
+	if passed {
+		p.deepPassed.Add(1)
+	}
+}
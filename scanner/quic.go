@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// QUICProber 测试 QUIC (UDP) 握手耗时，用于挑选 HTTP/3 和 QUIC 传输表现好的 IP
+// 端口与 TCP 相同（默认 443），握手时提供 h3
+type QUICProber struct {
+	SNI     string
+	Timeout time.Duration
+}
+
+// NewQUICProber 创建 QUIC 握手探测器
+func NewQUICProber(opts ScanOptions) *QUICProber {
+	return &QUICProber{SNI: domain2SNI(opts.Domain), Timeout: opts.Timeout}
+}
+
+func (p *QUICProber) Probe(ctx context.Context, ip string, withTrace bool) (ProbeResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialQUIC(ctx, ip, p.SNI, nil)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	defer conn.CloseWithError(0, "")
+
+	res := ProbeResult{Latency: time.Since(start), ALPN: conn.ConnectionState().TLS.NegotiatedProtocol}
+
+	if withTrace {
+		// trace 请求单独计时，不占用握手的超时
+		traceCtx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+		defer cancel()
+		res.Trace, _ = fetchTraceH3(traceCtx, conn, p.SNI)
+	}
+	return res, nil
+}
+
+// dialQUIC 通过 UDP 连接指定 IP 并完成 QUIC 握手，tlsConf 为 nil 时使用 sni 和 h3 生成配置
+func dialQUIC(ctx context.Context, ip string, sni string, tlsConf *tls.Config) (*quic.Conn, error) {
+	host, port, err := SplitAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf == nil {
+		tlsConf = &tls.Config{
+			ServerName:         sni,
+			InsecureSkipVerify: true,
+			NextProtos:         []string{http3.NextProtoH3},
+		}
+	}
+	return quic.DialAddr(ctx, net.JoinHostPort(host, strconv.Itoa(port)), tlsConf, nil)
+}
+
+// fetchTraceH3 在已建立的 QUIC 连接上以 HTTP/3 请求 /cdn-cgi/trace
+func fetchTraceH3(ctx context.Context, conn *quic.Conn, host string) (map[string]string, error) {
+	req, err := newTraceRequest(host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := new(http3.Transport).NewClientConn(conn).RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return readTrace(resp)
+}
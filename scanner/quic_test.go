@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newH3Server 启动 HTTP/3 测试服务器，返回 UDP 地址（"IP:端口"）
+// 证书借用 httptest 自带的测试证书
+func newH3Server(t *testing.T) string {
+	t.Helper()
+	tlsSrv := httptest.NewUnstartedServer(nil)
+	tlsSrv.StartTLS()
+	tlsConf := tlsSrv.TLS.Clone()
+	tlsSrv.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http3.Server{Handler: testHandler(), TLSConfig: http3.ConfigureTLSConfig(tlsConf)}
+	go srv.Serve(conn)
+	t.Cleanup(func() {
+		srv.Close()
+		conn.Close()
+	})
+	return conn.LocalAddr().String()
+}
+
+func TestQUICProber(t *testing.T) {
+	addr := newH3Server(t)
+
+	p := &QUICProber{SNI: "example.com", Timeout: 2 * time.Second}
+	res, err := p.Probe(context.Background(), addr, true)
+	if err != nil {
+		t.Fatalf("QUIC 探测失败: %v", err)
+	}
+	if res.ALPN != "h3" {
+		t.Errorf("ALPN = %q，期望 h3", res.ALPN)
+	}
+	if res.Trace["colo"] != "HKG" || res.Trace["http"] != "http/3" {
+		t.Errorf("trace = %v", res.Trace)
+	}
+}
+
+func TestQUICProberNoServer(t *testing.T) {
+	// 没有 QUIC 服务的 UDP 端口应在超时内失败
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	p := &QUICProber{SNI: "example.com", Timeout: 300 * time.Millisecond}
+	if _, err := p.Probe(context.Background(), conn.LocalAddr().String(), false); err == nil {
+		t.Fatal("没有 QUIC 服务时探测应失败")
+	}
+}
+
+func TestSpeedHTTP3(t *testing.T) {
+	addr := newH3Server(t)
+
+	speed, samples, err := testSpeed(context.Background(), addr, "example.com/__down", 600*time.Millisecond, "3", true)
+	if err != nil {
+		t.Fatalf("HTTP/3 测速失败: %v", err)
+	}
+	if speed <= 0 || len(samples) == 0 {
+		t.Errorf("HTTP/3 测速结果 %.2f Mbps，采样 %d 个", speed, len(samples))
+	}
+}
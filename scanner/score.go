@@ -0,0 +1,329 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// scoreVars 综合得分中可用的指标
+// history 为历史信誉 (0~1，无记录为 0.5)，其余指标除以参与排序的结果中的最大值归一化到 0~1，
+// 方向不变：latency 为 1 表示延迟最高，speed 为 1 表示速度最快；按比例而不是按最小/最大值拉伸，
+// 51 Mbps 和 50 Mbps 归一化后仍然只差 2%
+var scoreVars = map[string]func(r FinalResult) float64{
+	"latency":   func(r FinalResult) float64 { return float64(r.RawLatency) },
+	"p95":       func(r FinalResult) float64 { return float64(r.P95Latency) },
+	"jitter":    func(r FinalResult) float64 { return r.Jitter },
+	"loss":      func(r FinalResult) float64 { return r.LossRate },
+	"ws":        func(r FinalResult) float64 { return float64(r.WSLatency) },
+	"speed":     func(r FinalResult) float64 { return r.DownloadMBs },
+	"aggregate": func(r FinalResult) float64 { return r.AggregateMBs },
+	"upload":    func(r FinalResult) float64 { return r.UploadMBs },
+	"stability": func(r FinalResult) float64 { return r.Stability() },
+}
+
+// ScoreVarNames 返回综合得分中可用的指标名（已排序）
+func ScoreVarNames() []string {
+	names := []string{"history"}
+	for name := range scoreVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Scorer 按用户配置的权重或表达式计算结果的综合得分，得分越高越好
+// 为 nil 时各方法不做任何事，排序保持原有规则
+type Scorer struct {
+	expr scoreExpr
+	text string
+}
+
+// ParseScorer 解析综合得分的配置，支持两种写法：
+//   - 权重：speed=0.6,latency=-0.3,loss=-10，即各指标乘以权重后相加
+//   - 表达式：score = speed*0.6 - latency*0.3 - loss*10，支持 + - * / 和括号，"score =" 可省略
+func ParseScorer(s string) (*Scorer, error) {
+	text := strings.TrimSpace(s)
+	if name, rest, ok := strings.Cut(text, "="); ok && strings.TrimSpace(name) == "score" {
+		text = strings.TrimSpace(rest)
+	}
+	if text == "" {
+		return nil, fmt.Errorf("得分表达式为空")
+	}
+
+	var expr scoreExpr
+	var err error
+	if strings.Contains(text, "=") {
+		expr, err = parseWeights(text)
+	} else {
+		p := &scoreParser{input: text}
+		expr, err = p.parse()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Scorer{expr: expr, text: text}, nil
+}
+
+// String 返回得分规则的文本
+func (s *Scorer) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.text
+}
+
+// Rank 计算每个结果的综合得分（写入 Score），并按得分从高到低稳定排序
+// reputation 返回 IP 的历史信誉，为 nil 时 history 按 0.5 计算
+func (s *Scorer) Rank(results []FinalResult, reputation func(ip string) float64) {
+	if s == nil || len(results) == 0 {
+		return
+	}
+
+	// 各指标在本组结果中的最大值
+	hi := make(map[string]float64, len(scoreVars))
+	for name, get := range scoreVars {
+		for _, r := range results {
+			hi[name] = max(hi[name], get(r))
+		}
+	}
+
+	for i := range results {
+		vars := make(map[string]float64, len(scoreVars)+1)
+		for name, get := range scoreVars {
+			if hi[name] > 0 {
+				vars[name] = get(results[i]) / hi[name]
+			}
+		}
+		vars["history"] = 0.5
+		if reputation != nil {
+			vars["history"] = reputation(results[i].IP)
+		}
+		results[i].Score = s.expr.eval(vars)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+}
+
+// scoreExpr 得分表达式的语法树节点
+type scoreExpr interface {
+	eval(vars map[string]float64) float64
+}
+
+type scoreNum float64
+
+func (n scoreNum) eval(map[string]float64) float64 { return float64(n) }
+
+type scoreVar string
+
+func (v scoreVar) eval(vars map[string]float64) float64 { return vars[string(v)] }
+
+type scoreNeg struct{ x scoreExpr }
+
+func (n scoreNeg) eval(vars map[string]float64) float64 { return -n.x.eval(vars) }
+
+type scoreBinary struct {
+	op   byte
+	l, r scoreExpr
+}
+
+func (b scoreBinary) eval(vars map[string]float64) float64 {
+	l, r := b.l.eval(vars), b.r.eval(vars)
+	switch b.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	}
+	// 除数为 0 时按 0 计算，避免 NaN / Inf 打乱排序
+	if r == 0 {
+		return 0
+	}
+	return l / r
+}
+
+// parseWeights 解析 指标=权重 列表，生成 Σ 权重 × 指标 的表达式
+func parseWeights(text string) (scoreExpr, error) {
+	var expr scoreExpr
+	for _, item := range strings.Split(text, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("权重 %q 应为 指标=权重", item)
+		}
+		name = strings.TrimSpace(name)
+		if err := checkScoreVar(name); err != nil {
+			return nil, err
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("指标 %s 的权重 %q 不是数字", name, strings.TrimSpace(value))
+		}
+
+		term := scoreBinary{op: '*', l: scoreVar(name), r: scoreNum(weight)}
+		if expr == nil {
+			expr = term
+		} else {
+			expr = scoreBinary{op: '+', l: expr, r: term}
+		}
+	}
+	if expr == nil {
+		return nil, fmt.Errorf("没有指定任何权重")
+	}
+	return expr, nil
+}
+
+// checkScoreVar 检查指标名是否可用
+func checkScoreVar(name string) error {
+	if _, ok := scoreVars[name]; ok || name == "history" {
+		return nil
+	}
+	return fmt.Errorf("未知的指标 %q，可选: %s", name, strings.Join(ScoreVarNames(), "/"))
+}
+
+// scoreParser 表达式的递归下降解析器
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/") factor }
+//	factor = 数字 | 指标 | "-" factor | "(" expr ")"
+type scoreParser struct {
+	input string
+	pos   int
+}
+
+func (p *scoreParser) parse() (scoreExpr, error) {
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.input) {
+		return nil, p.unexpected()
+	}
+	return expr, nil
+}
+
+func (p *scoreParser) expr() (scoreExpr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.consume('+', '-')
+		if !ok {
+			return left, nil
+		}
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = scoreBinary{op: op, l: left, r: right}
+	}
+}
+
+func (p *scoreParser) term() (scoreExpr, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.consume('*', '/')
+		if !ok {
+			return left, nil
+		}
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = scoreBinary{op: op, l: left, r: right}
+	}
+}
+
+func (p *scoreParser) factor() (scoreExpr, error) {
+	if _, ok := p.consume('-'); ok {
+		x, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return scoreNeg{x}, nil
+	}
+	if _, ok := p.consume('('); ok {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.consume(')'); !ok {
+			return nil, fmt.Errorf("表达式缺少右括号")
+		}
+		return x, nil
+	}
+
+	p.skipSpace()
+	start := p.pos
+	if p.pos >= len(p.input) {
+		return nil, fmt.Errorf("表达式不完整")
+	}
+	c := p.peek()
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.input) && (unicode.IsDigit(p.peek()) || p.peek() == '.') {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的数字 %q", p.input[start:p.pos])
+		}
+		return scoreNum(n), nil
+	case unicode.IsLetter(c):
+		for p.pos < len(p.input) && (unicode.IsLetter(p.peek()) || unicode.IsDigit(p.peek()) || p.peek() == '_') {
+			p.pos += utf8.RuneLen(p.peek())
+		}
+		name := p.input[start:p.pos]
+		if err := checkScoreVar(name); err != nil {
+			return nil, err
+		}
+		return scoreVar(name), nil
+	}
+	return nil, p.unexpected()
+}
+
+// peek 返回当前位置的字符（按 UTF-8 解码），无效的编码返回 utf8.RuneError
+func (p *scoreParser) peek() rune {
+	c, _ := utf8.DecodeRuneInString(p.input[p.pos:])
+	return c
+}
+
+// unexpected 返回当前位置字符无法识别的错误
+func (p *scoreParser) unexpected() error {
+	return fmt.Errorf("表达式第 %d 个字符 %q 无法识别", utf8.RuneCountInString(p.input[:p.pos])+1, p.peek())
+}
+
+// consume 跳过空白后，下一个字符是 ops 之一时读取并返回它
+func (p *scoreParser) consume(ops ...byte) (byte, bool) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0, false
+	}
+	for _, op := range ops {
+		if p.input[p.pos] == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+func (p *scoreParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
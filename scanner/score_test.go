@@ -0,0 +1,142 @@
+package scanner
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestScorerEval(t *testing.T) {
+	vars := map[string]float64{"speed": 1, "latency": 0.5, "loss": 0.1, "history": 0.8}
+
+	tests := []struct {
+		text string
+		want float64
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"10-4-3", 3},
+		{"8/4/2", 1},
+		{"2*3+4*5", 26},
+		{"-2*3", -6},
+		{"--2", 2},
+		{"2*-3", -6},
+		{"-(1+2)", -3},
+		{".5+1.", 1.5},
+		{"speed*0.6 - latency*0.3", 0.45},
+		{"score = speed*0.6 - latency*0.3", 0.45},
+		{"  score=speed - (latency + loss) * 2 ", -0.2},
+		{"history*2", 1.6},
+		// 除数为 0 时按 0 计算
+		{"speed/0", 0},
+		{"speed/(latency-0.5)", 0},
+		// 权重写法
+		{"speed=0.6,latency=-0.3", 0.45},
+		{"speed=1, loss=-10, history=0.5,", 0.4},
+	}
+	for _, tt := range tests {
+		s, err := ParseScorer(tt.text)
+		if err != nil {
+			t.Errorf("ParseScorer(%q): %v", tt.text, err)
+			continue
+		}
+		if got := s.expr.eval(vars); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%q = %v，期望 %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseScorerErrors(t *testing.T) {
+	tests := []struct {
+		text    string
+		wantErr string
+	}{
+		{"", "为空"},
+		{"score =", "为空"},
+		{"foo*2", "未知的指标"},
+		{"speed + latency2", "未知的指标"},
+		{"速度*2", `未知的指标 "速度"`},
+		{"speed * ¥", `第 9 个字符 '¥' 无法识别`},
+		{"speed\xff", "无法识别"},
+		{"٣", "无效的数字"},
+		{"speed*", "不完整"},
+		{"-", "不完整"},
+		{"(speed", "右括号"},
+		{"speed)", "无法识别"},
+		{"speed latency", "无法识别"},
+		{"speed # 1", "无法识别"},
+		{"1..2", "无效的数字"},
+		{"speed**2", "无法识别"},
+		{",", "无法识别"},
+		{"speed=abc", "不是数字"},
+		{"foo=1", "未知的指标"},
+		{"=1", "未知的指标"},
+		{"speed=1,latency", "指标=权重"},
+		{"speed=1=2", "不是数字"},
+		{" , ,speed", "无法识别"},
+	}
+	for _, tt := range tests {
+		s, err := ParseScorer(tt.text)
+		if err == nil {
+			t.Errorf("ParseScorer(%q) 应返回错误，实际得到 %q", tt.text, s)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseScorer(%q) 错误 = %v，期望包含 %q", tt.text, err, tt.wantErr)
+		}
+	}
+}
+
+func TestScorerRank(t *testing.T) {
+	s, err := ParseScorer("speed - latency + history")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 延迟全部为 0（如只做了测速），最大值为 0 的指标按 0 计算，不产生 NaN
+	results := []FinalResult{
+		{IP: "1.0.0.1", DownloadMBs: 50},
+		{IP: "1.0.0.2", DownloadMBs: 100},
+		{IP: "1.0.0.3"},
+		{IP: "1.0.0.4", DownloadMBs: 50},
+	}
+	s.Rank(results, nil)
+	var order []string
+	for _, r := range results {
+		order = append(order, r.IP)
+		if math.IsNaN(r.Score) || math.IsInf(r.Score, 0) {
+			t.Errorf("%s 得分为 %v", r.IP, r.Score)
+		}
+	}
+	// 得分相同时保持原有顺序
+	if got := strings.Join(order, ","); got != "1.0.0.2,1.0.0.1,1.0.0.4,1.0.0.3" {
+		t.Errorf("排序 = %s", got)
+	}
+	if results[0].Score != 1.5 || results[1].Score != 1 || results[3].Score != 0.5 {
+		t.Errorf("得分 = %v/%v/%v，期望 1.5/1/0.5", results[0].Score, results[1].Score, results[3].Score)
+	}
+
+	// 指标按最大值归一化，历史信誉来自 reputation
+	results = []FinalResult{
+		{IP: "1.0.0.1", DownloadMBs: 100, RawLatency: 200},
+		{IP: "1.0.0.2", DownloadMBs: 80, RawLatency: 100},
+		{IP: "1.0.0.3", DownloadMBs: 80, RawLatency: 100},
+	}
+	reputation := map[string]float64{"1.0.0.1": 0.5, "1.0.0.2": 0.5, "1.0.0.3": 0.9}
+	s.Rank(results, func(ip string) float64 { return reputation[ip] })
+	order = order[:0]
+	for _, r := range results {
+		order = append(order, r.IP)
+	}
+	// 1.0.0.1: 1-1+0.5，1.0.0.2: 0.8-0.5+0.5，1.0.0.3: 0.8-0.5+0.9
+	if got := strings.Join(order, ","); got != "1.0.0.3,1.0.0.2,1.0.0.1" {
+		t.Errorf("排序 = %s", got)
+	}
+
+	// nil Scorer 不改变顺序
+	var none *Scorer
+	none.Rank(results, nil)
+	if results[0].IP != "1.0.0.3" || none.String() != "" {
+		t.Error("nil Scorer 不应修改结果")
+	}
+}
@@ -0,0 +1,92 @@
+package scanner
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// applyLatencyStats 根据多轮握手的耗时计算丢包率、延迟分布与抖动
+// samples 为成功轮次的耗时，rounds 为总轮数
+func applyLatencyStats(res *FinalResult, samples []time.Duration, rounds int) {
+	ms := make([]float64, len(samples))
+	for i, d := range samples {
+		ms[i] = float64(d.Microseconds()) / 1000
+	}
+	sort.Float64s(ms)
+
+	var sum float64
+	for _, v := range ms {
+		sum += v
+	}
+	avg := sum / float64(len(ms))
+
+	// 抖动：延迟的标准差
+	var variance float64
+	for _, v := range ms {
+		variance += (v - avg) * (v - avg)
+	}
+	variance /= float64(len(ms))
+
+	res.LossRate = float64(rounds-len(samples)) * 100 / float64(rounds)
+	res.MinLatency = int64(math.Round(ms[0]))
+	res.MaxLatency = int64(math.Round(ms[len(ms)-1]))
+	res.P95Latency = int64(math.Round(percentile(ms, 95)))
+	res.Jitter = math.Sqrt(variance)
+	res.RawLatency = int64(math.Round(avg)) // 存入纯数字
+	res.Latency = fmt.Sprintf("%dms", res.RawLatency)
+}
+
+// percentile 计算已排序切片的百分位数（最近秩法）
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// SortResults 按指定指标排序扫描结果，指标相同时按平均延迟，再相同时按 IP 和端口，保证同样的结果总是同样的顺序
+func SortResults(results []FinalResult, by string) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		switch by {
+		case "loss":
+			if a.LossRate != b.LossRate {
+				return a.LossRate < b.LossRate
+			}
+		case "jitter":
+			if a.Jitter != b.Jitter {
+				return a.Jitter < b.Jitter
+			}
+		case "p95":
+			if a.P95Latency != b.P95Latency {
+				return a.P95Latency < b.P95Latency
+			}
+		}
+		if a.RawLatency != b.RawLatency {
+			return a.RawLatency < b.RawLatency
+		}
+		if a.IP != b.IP {
+			return a.IP < b.IP
+		}
+		return a.Port < b.Port
+	})
+}
+
+// BestPortPerIP 同一 IP 有多个端口的结果时只保留最靠前的一个，results 应已排好序
+func BestPortPerIP(results []FinalResult) []FinalResult {
+	seen := make(map[string]bool, len(results))
+	var kept []FinalResult
+	for _, r := range results {
+		if !seen[r.IP] {
+			seen[r.IP] = true
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// sampleInterval 测速时记录一次吞吐量的间隔
+	sampleInterval = 250 * time.Millisecond
+	// stallFraction 采样速率低于中位数的该比例时记为卡顿
+	stallFraction = 0.3
+)
+
+// throughputSampler 在下载循环中按 sampleInterval 记录每段时间的速率 (Mbps)
+// 一次 Read 阻塞超过多个间隔时，中间的间隔记为 0，数据计入最后一个间隔
+type throughputSampler struct {
+	last    time.Time
+	bytes   int64
+	samples []float64
+}
+
+// newThroughputSampler 从 start（首字节时间）开始采样
+func newThroughputSampler(start time.Time) *throughputSampler {
+	return &throughputSampler{last: start}
+}
+
+// add 记录一次读取到的字节数
+func (s *throughputSampler) add(n int, now time.Time) {
+	s.bytes += int64(n)
+	elapsed := now.Sub(s.last)
+	if elapsed < sampleInterval {
+		return
+	}
+
+	intervals := int(elapsed / sampleInterval)
+	for i := 1; i < intervals; i++ {
+		s.samples = append(s.samples, 0)
+	}
+	s.samples = append(s.samples, mbps(s.bytes, sampleInterval))
+	s.last = s.last.Add(time.Duration(intervals) * sampleInterval)
+	s.bytes = 0
+}
+
+// finish 结束采样，剩余不足一个间隔的部分超过半个间隔时按实际时长计入，返回全部采样
+func (s *throughputSampler) finish(now time.Time) []float64 {
+	if elapsed := now.Sub(s.last); elapsed >= sampleInterval/2 {
+		s.samples = append(s.samples, mbps(s.bytes, elapsed))
+	}
+	return s.samples
+}
+
+// mbps 将字节数与耗时换算为 Mbps，公式同 toMbps
+func mbps(bytes int64, d time.Duration) float64 {
+	return float64(bytes) * 8 / (1024 * 1024) / d.Seconds()
+}
+
+// applyThroughputStats 根据吞吐量采样计算中位数、P10、变异系数和卡顿次数
+// 连续低于中位数 stallFraction 的采样算作一次卡顿
+func applyThroughputStats(res *FinalResult, samples []float64) {
+	res.SpeedSamples = samples
+	if len(samples) == 0 {
+		return
+	}
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	avg := sum / float64(len(sorted))
+	var variance float64
+	for _, v := range sorted {
+		variance += (v - avg) * (v - avg)
+	}
+	variance /= float64(len(sorted))
+
+	res.SpeedMedian = median(sorted)
+	res.SpeedP10 = percentile(sorted, 10)
+	if avg > 0 {
+		res.SpeedCV = math.Sqrt(variance) / avg
+	}
+
+	res.Stalls = 0
+	stalled := false
+	for _, v := range samples {
+		low := v < res.SpeedMedian*stallFraction
+		if low && !stalled {
+			res.Stalls++
+		}
+		stalled = low
+	}
+}
+
+// median 计算已排序切片的中位数
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Stability 返回测速的稳定性 (0~1)：波动越大、卡顿越多越低，没有采样时为 1
+func (r FinalResult) Stability() float64 {
+	if len(r.SpeedSamples) == 0 {
+		return 1
+	}
+	// 变异系数为 0 时为 1，为 1（标准差等于平均值）时减半；每次卡顿再按占全部采样的比例扣减
+	stalled := math.Min(float64(r.Stalls)/float64(len(r.SpeedSamples)), 1)
+	return 1 / (1 + r.SpeedCV) * (1 - stalled)
+}
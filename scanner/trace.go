@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// fetchTrace 在已建立的连接上请求 /cdn-cgi/trace，并解析 key=value 格式的响应
+// 返回的 map 中包含 colo（数据中心）、loc（国家/地区）、http、tls 等字段
+// TLS 握手协商了 h2 时以 HTTP/2 发送请求，否则使用 HTTP/1.1
+func fetchTrace(conn net.Conn, host string) (map[string]string, error) {
+	req, err := newTraceRequest(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *http.Response
+	if negotiatedProtocol(conn) == "h2" {
+		var cc *http2.ClientConn
+		if cc, err = new(http2.Transport).NewClientConn(conn); err != nil {
+			return nil, err
+		}
+		resp, err = cc.RoundTrip(req)
+	} else {
+		if err := req.Write(conn); err != nil {
+			return nil, err
+		}
+		resp, err = http.ReadResponse(bufio.NewReader(conn), req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readTrace(resp)
+}
+
+// newTraceRequest 构造 /cdn-cgi/trace 请求
+func newTraceRequest(host string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", "https://"+host+"/cdn-cgi/trace", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Close = true // 请求完即关闭，避免服务端保持连接
+	return req, nil
+}
+
+// readTrace 检查 trace 响应并解析响应体
+func readTrace(resp *http.Response) (map[string]string, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("trace 状态码异常: %d", resp.StatusCode)
+	}
+
+	// trace 响应很小，限制读取长度防止异常响应
+	return parseTrace(io.LimitReader(resp.Body, 4096))
+}
+
+// parseTrace 解析 /cdn-cgi/trace 的响应体
+func parseTrace(r io.Reader) (map[string]string, error) {
+	trace := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if ok {
+			trace[key] = value
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if trace["colo"] == "" {
+		return nil, fmt.Errorf("trace 响应中没有 colo 字段")
+	}
+	return trace, nil
+}
+
+// coloAllowed 判断数据中心是否通过 keep / exclude 过滤
+// 两个列表都为空时全部放行；设置了过滤但 colo 为空时视为不通过
+func coloAllowed(colo string, keep, exclude []string) bool {
+	if len(keep) == 0 && len(exclude) == 0 {
+		return true
+	}
+	if colo == "" {
+		return false
+	}
+	for _, c := range exclude {
+		if strings.EqualFold(c, colo) {
+			return false
+		}
+	}
+	if len(keep) == 0 {
+		return true
+	}
+	for _, c := range keep {
+		if strings.EqualFold(c, colo) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package scanner
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// uploadBlock 上传数据的随机内容，所有上传共用，随机数据避免被链路压缩
+var uploadBlock = sync.OnceValue(func() []byte {
+	block := make([]byte, 64*1024)
+	rand.Read(block)
+	return block
+})
+
+// uploadBody 上传的请求体，持续产生数据直到采样时间结束后返回 EOF
+// 计数的是传输层实际取走（即写入连接）的字节数
+type uploadBody struct {
+	duration time.Duration
+	bar      *progressbar.ProgressBar
+
+	start atomic.Int64 // 第一次被读取的时间 (UnixNano)，即连接建立完成、开始上传的时间
+	sent  atomic.Int64
+	done  atomic.Bool // 采样时间已到，请求体已结束
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	now := time.Now()
+	if start := b.start.Load(); start == 0 {
+		b.start.Store(now.UnixNano())
+	} else if now.Sub(time.Unix(0, start)) >= b.duration {
+		b.done.Store(true)
+		return 0, io.EOF
+	}
+
+	n := copy(p, uploadBlock())
+	b.sent.Add(int64(n))
+	b.bar.Add(n)
+	return n, nil
+}
+
+// TestUpload 通过指定 IP 向 endpoint POST 随机数据，持续 duration 后结束请求，返回服务端接收的速率 (Mbps)
+// ip 可以带端口，endpoint 的写法同 TestSpeed 的 domain（如 speed.cloudflare.com/__up）
+func TestUpload(ctx context.Context, ip string, endpoint string, duration time.Duration) (float64, error) {
+	return testUpload(ctx, ip, endpoint, duration, "", false)
+}
+
+// testUpload 是 TestUpload 的实现，version 和 quiet 含义同 testSpeed
+// 耗时从开始发送数据算到收到服务端的响应为止，服务端确认收完全部数据后才算上传完成
+func testUpload(ctx context.Context, ip string, endpoint string, duration time.Duration, version string, quiet bool) (float64, error) {
+	client, host := newSpeedClient(ip, endpoint, duration, version)
+	defer client.CloseIdleConnections()
+	bar := newSpeedBar(quiet)
+
+	body := &uploadBody{duration: duration, bar: bar}
+	req, err := http.NewRequestWithContext(ctx, "POST", speedURL("", endpoint), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Host = host
+	req.ContentLength = -1 // 长度未知，以分块编码发送
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	end := time.Now()
+
+	bar.Describe("Done")
+	bar.Finish()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("上传测速状态码异常: %d", resp.StatusCode)
+	}
+	if err := checkProto(resp, version); err != nil {
+		return 0, err
+	}
+	// 服务端没读完请求体就返回了响应，说明该地址不接收上传，计数不可信
+	if !body.done.Load() {
+		return 0, fmt.Errorf("服务端未接收完上传数据就已响应")
+	}
+	start := body.start.Load()
+	if start == 0 {
+		return 0, fmt.Errorf("上传数据不足")
+	}
+	return toMbps(body.sent.Load(), end.Sub(time.Unix(0, start)))
+}
@@ -0,0 +1,190 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// wsGUID 计算 Sec-WebSocket-Accept 使用的固定 GUID (RFC 6455)
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WSOptions WebSocket 升级验证的参数
+type WSOptions struct {
+	Domain      string        // 测速地址，Host 为空时使用其中的域名
+	Host        string        // Host 头和 SNI，即 Worker 的域名
+	Path        string        // 升级请求的路径，如 /ws 或 /?ed=2048
+	Scheme      string        // https（默认）或 http，http 时不做 TLS 握手
+	Timeout     time.Duration // 单个 IP 从建立连接到收到 101 的超时
+	Concurrency int           // 同时验证的 IP 数
+	Limit       int           // 凑够 Limit 个升级成功的 IP 后停止验证，0 为全部验证
+
+	Progress *Progress // 进度计数器，可为 nil
+}
+
+// CheckWebSocket 通过指定 IP 发送 WebSocket 升级请求，返回从发出请求到收到 101 的耗时
+// ip 可以带端口（格式见 SplitAddr），服务端返回其他状态码或 Sec-WebSocket-Accept 不匹配时返回错误
+func CheckWebSocket(ctx context.Context, ip string, opts WSOptions) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	conn, _, latency, err := wsUpgrade(ctx, ip, opts)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return latency, nil
+}
+
+// wsUpgrade 完成 WebSocket 升级，返回升级后的连接和耗时，连接的读写截止时间为 ctx 的截止时间
+// 服务端紧跟在 101 之后发送的数据可能已被读入返回的 Reader，之后应从 Reader 读取
+func wsUpgrade(ctx context.Context, ip string, opts WSOptions) (net.Conn, *bufio.Reader, time.Duration, error) {
+	host := opts.Host
+	if host == "" {
+		host = domain2SNI(opts.Domain)
+	}
+	conn, err := dialHTTP(ctx, ip, host, opts.Scheme == "http")
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	latency, reader, err := wsHandshake(conn, host, opts)
+	if err != nil {
+		conn.Close()
+		return nil, nil, 0, err
+	}
+	return conn, reader, latency, nil
+}
+
+// wsHandshake 在已建立的连接上发送升级请求并校验响应
+func wsHandshake(conn net.Conn, host string, opts WSOptions) (time.Duration, *bufio.Reader, error) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, err := http.NewRequest("GET", schemeOf(opts.Scheme)+"://"+host+opts.Path, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	start := time.Now()
+	if err := req.Write(conn); err != nil {
+		return 0, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return 0, nil, fmt.Errorf("升级失败: %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return 0, nil, fmt.Errorf("Sec-WebSocket-Accept 不匹配")
+	}
+	return latency, reader, nil
+}
+
+// wsAccept 计算 key 对应的 Sec-WebSocket-Accept
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// RunWSCheck 按顺序对扫描结果做 WebSocket 升级验证，返回升级成功的结果（保持原有顺序）并记录升级耗时
+// ctx 取消后停止验证，返回已经验证通过的结果
+func RunWSCheck(ctx context.Context, results []FinalResult, opts WSOptions) []FinalResult {
+	opts.Progress.SetStage("ws")
+
+	workerCount := opts.Concurrency
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		passed int
+	)
+	ok := make([]bool, len(results))
+	checked := make([]FinalResult, len(results))
+	// enough 判断是否已经凑够 Limit 个升级成功的结果
+	enough := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return opts.Limit > 0 && passed >= opts.Limit
+	}
+
+	jobs := make(chan int)
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				r := results[idx]
+				latency, err := CheckWebSocket(ctx, r.Addr(), opts)
+				if ctx.Err() != nil {
+					continue
+				}
+				if err != nil {
+					fmt.Printf("WebSocket 验证失败: [%s] %v\n", r.Addr(), err)
+					continue
+				}
+				r.WSLatency = max(latency.Milliseconds(), 1) // 不足 1ms 记为 1ms，0 表示未验证
+				mu.Lock()
+				checked[idx] = r
+				ok[idx] = true
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// 投放任务，中断或凑够结果后不再派发
+	tried := 0
+	for idx := range results {
+		if ctx.Err() != nil || enough() {
+			break
+		}
+		jobs <- idx
+		tried++
+	}
+	close(jobs)
+	wg.Wait()
+
+	var kept []FinalResult
+	for idx, r := range checked {
+		if ok[idx] {
+			kept = append(kept, r)
+		}
+	}
+	// 并发验证时可能多个工人同时通过，只保留前 Limit 个
+	if opts.Limit > 0 && len(kept) > opts.Limit {
+		kept = kept[:opts.Limit]
+	}
+
+	if ctx.Err() != nil {
+		fmt.Printf("\nWebSocket 验证已中断，保留已通过的 %d 个结果\n", len(kept))
+	} else {
+		fmt.Printf("WebSocket 验证：%d/%d 个 IP 升级成功\n", passed, tried)
+	}
+	return kept
+}
@@ -0,0 +1,270 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeWSFrame 写入一个不分片的 WebSocket 帧，客户端发送的帧必须加掩码
+func writeWSFrame(w io.Writer, opcode byte, payload []byte, masked bool) error {
+	header := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, maskBit|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	data := append([]byte(nil), payload...)
+	if masked {
+		mask := make([]byte, 4)
+		rand.Read(mask)
+		header = append(header, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	_, err := w.Write(append(header, data...))
+	return err
+}
+
+// readWSFrame 读取一个不分片的 WebSocket 帧，带掩码时还原负载
+func readWSFrame(r *bufio.Reader) (byte, []byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext)
+	}
+
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// wsEcho 原样发回客户端的数据帧，回应 ping，收到 close 时回应后退出
+// 客户端的帧未加掩码时按协议要求直接断开
+func wsEcho(conn net.Conn, r *bufio.Reader) {
+	for {
+		head, err := r.Peek(2)
+		if err != nil || head[1]&0x80 == 0 {
+			return
+		}
+		opcode, payload, err := readWSFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case 0x8: // close
+			writeWSFrame(conn, 0x8, payload, false)
+			return
+		case 0x9: // ping
+			opcode = 0xA
+		}
+		if err := writeWSFrame(conn, opcode, payload, false); err != nil {
+			return
+		}
+	}
+}
+
+// newWSServer 启动 WebSocket 回显测试服务器
+// /ws 正常升级后原样发回收到的消息，/bad-accept 返回错误的 Sec-WebSocket-Accept，其他路径返回 403
+func newWSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	upgrade := func(accept func(key string) string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" {
+				http.Error(w, "not a websocket request", http.StatusBadRequest)
+				return
+			}
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+				accept(r.Header.Get("Sec-WebSocket-Key")))
+			if buf.Flush() != nil {
+				return
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			wsEcho(conn, buf.Reader)
+		}
+	}
+	mux.HandleFunc("/ws", upgrade(wsAccept))
+	mux.HandleFunc("/bad-accept", upgrade(func(string) string { return wsAccept("other") }))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckWebSocket(t *testing.T) {
+	srv := newWSServer(t)
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{"/ws", ""},
+		{"/", "403"},
+		{"/bad-accept", "Sec-WebSocket-Accept"},
+	}
+	for _, tt := range tests {
+		opts := WSOptions{Host: "ws.example.com", Path: tt.path, Timeout: 2 * time.Second}
+		latency, err := CheckWebSocket(context.Background(), serverAddr(srv), opts)
+		if tt.wantErr == "" {
+			if err != nil || latency <= 0 {
+				t.Errorf("%s: 升级应成功，实际耗时 %v，错误 %v", tt.path, latency, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: 错误应包含 %q，实际为 %v", tt.path, tt.wantErr, err)
+		}
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	srv := newWSServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	opts := WSOptions{Host: "ws.example.com", Path: "/ws"}
+	conn, reader, _, err := wsUpgrade(ctx, serverAddr(srv), opts)
+	if err != nil {
+		t.Fatalf("升级失败: %v", err)
+	}
+	defer conn.Close()
+
+	// 文本消息、需要 16 位长度的二进制消息和 ping 都应原样返回
+	messages := []struct {
+		opcode, want byte
+		payload      []byte
+	}{
+		{0x1, 0x1, []byte("hello")},
+		{0x2, 0x2, bytes.Repeat([]byte{0xAB}, 300)},
+		{0x9, 0xA, []byte("ping")},
+	}
+	for _, m := range messages {
+		if err := writeWSFrame(conn, m.opcode, m.payload, true); err != nil {
+			t.Fatal(err)
+		}
+		opcode, payload, err := readWSFrame(reader)
+		if err != nil {
+			t.Fatalf("读取回显失败: %v", err)
+		}
+		if opcode != m.want || !bytes.Equal(payload, m.payload) {
+			t.Errorf("发送 opcode %d、%d 字节，收到 opcode %d、%d 字节", m.opcode, len(m.payload), opcode, len(payload))
+		}
+	}
+
+	// 正常关闭
+	if err := writeWSFrame(conn, 0x8, []byte{0x03, 0xE8}, true); err != nil {
+		t.Fatal(err)
+	}
+	if opcode, _, err := readWSFrame(reader); err != nil || opcode != 0x8 {
+		t.Errorf("关闭时收到 opcode %d，错误 %v", opcode, err)
+	}
+}
+
+func TestRunWSCheckLimit(t *testing.T) {
+	good := newWSServer(t)
+	// 不支持 WebSocket 的地址：所有路径都返回 403
+	bad := httptest.NewTLSServer(http.NotFoundHandler())
+	defer bad.Close()
+
+	result := func(srv *httptest.Server, name string) FinalResult {
+		_, port, _ := SplitAddr(serverAddr(srv))
+		return FinalResult{IP: "127.0.0.1", Port: port, Colo: name}
+	}
+	results := []FinalResult{
+		result(bad, "bad1"),
+		result(good, "good1"),
+		result(bad, "bad2"),
+		result(good, "good2"),
+		result(good, "good3"),
+		result(bad, "bad3"),
+		result(good, "good4"),
+	}
+
+	tests := []struct {
+		concurrency int
+		limit       int
+		want        []string
+	}{
+		{1, 2, []string{"good1", "good2"}},
+		{4, 3, []string{"good1", "good2", "good3"}},
+		{4, 0, []string{"good1", "good2", "good3", "good4"}},
+	}
+	for _, tt := range tests {
+		opts := WSOptions{Host: "ws.example.com", Path: "/ws", Timeout: 2 * time.Second, Concurrency: tt.concurrency, Limit: tt.limit}
+		kept := RunWSCheck(context.Background(), results, opts)
+
+		var names []string
+		for _, r := range kept {
+			names = append(names, r.Colo)
+			if r.WSLatency < 1 {
+				t.Errorf("%s 未记录升级耗时", r.Colo)
+			}
+		}
+		if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("并发 %d、Limit %d 保留 %v，期望 %v", tt.concurrency, tt.limit, names, tt.want)
+		}
+	}
+	if results[1].WSLatency != 0 {
+		t.Error("RunWSCheck 不应修改传入的结果")
+	}
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+)
+
+// Blocklist 自动维护的屏蔽列表：测速失败的 IP 在 TTL 内不再参与抽样
+// 文件为 JSON 对象，IP → 过期时间
+type Blocklist struct {
+	path string
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+	added   int
+}
+
+// LoadBlocklist 读取屏蔽列表并丢弃已过期的条目，文件不存在时返回空列表
+func LoadBlocklist(path string, ttl time.Duration) (*Blocklist, error) {
+	b := &Blocklist{path: path, ttl: ttl, entries: make(map[string]time.Time)}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &b.entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	now := time.Now()
+	for ip, expires := range b.entries {
+		if !expires.After(now) {
+			delete(b.entries, ip)
+		}
+	}
+	return b, nil
+}
+
+// IPs 返回仍在有效期内的 IP，按字典序排列
+func (b *Blocklist) IPs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ips := make([]string, 0, len(b.entries))
+	for ip := range b.entries {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// Add 屏蔽一个 IP，已存在时延长过期时间
+func (b *Blocklist) Add(ip string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.entries[ip]; !ok {
+		b.added++
+	}
+	b.entries[ip] = time.Now().Add(b.ttl)
+}
+
+// RecordDeep 测速未达标的 IP 加入屏蔽列表，可作为 DeepTestOptions.OnResult 使用
+func (b *Blocklist) RecordDeep(r scanner.FinalResult, ok bool) {
+	if !ok {
+		b.Add(r.IP)
+	}
+}
+
+// Save 写入屏蔽列表，返回本次新增的条目数
+func (b *Blocklist) Save() (int, error) {
+	b.mu.Lock()
+	content, err := json.MarshalIndent(b.entries, "", "    ")
+	added := b.added
+	b.added = 0
+	b.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return added, WriteFileAtomic(b.path, content)
+}
+
+// LoadExclusions 读取 -exclude 文件和 -blocklist 中未过期的 IP，合并为抽样时的排除集合
+// 未指定 -blocklist 时返回的屏蔽列表为 nil；两者都未指定时排除集合为 nil
+func LoadExclusions(c Config) (*IPSet, *Blocklist, error) {
+	var set *IPSet
+	if c.Exclude != "" {
+		var err error
+		if set, err = LoadIPSet(c.Exclude); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.Blocklist == "" {
+		return set, nil, nil
+	}
+
+	blocklist, err := LoadBlocklist(c.Blocklist, c.BlockTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if set == nil {
+		set = NewIPSet()
+	}
+	for _, ip := range blocklist.IPs() {
+		nets, err := ParseIPRange(ip)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", c.Blocklist, err)
+		}
+		set.Add(nets[0])
+	}
+	return set, blocklist, nil
+}
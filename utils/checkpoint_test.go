@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+)
+
+// countingProber 记录每个地址完成探测的次数，探测 limit 次后取消扫描
+type countingProber struct {
+	scanner.Prober
+	mu     sync.Mutex
+	calls  int
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (p *countingProber) Probe(ctx context.Context, ip string, withTrace bool) (scanner.ProbeResult, error) {
+	p.mu.Lock()
+	p.calls++
+	if p.limit > 0 && p.calls > p.limit {
+		p.cancel()
+	}
+	p.mu.Unlock()
+	return p.Prober.Probe(ctx, ip, withTrace)
+}
+
+// newListeners 启动 n 个只接受连接的本地 TCP 监听，返回 "IP:端口"
+func newListeners(t *testing.T, n int) []string {
+	t.Helper()
+	var addrs []string
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+		addrs = append(addrs, ln.Addr().String())
+	}
+	return addrs
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	listeners := newListeners(t, 6)
+
+	// 监听地址自带端口，网段按 -ports 展开到拒绝连接的端口 1，抽样结果由种子决定
+	c := testDefaults()
+	c.IPFile = filepath.Join(dir, "ip.txt")
+	c.Checkpoint = filepath.Join(dir, "scan.checkpoint.json")
+	c.Ports = "1"
+	c.Probe = "tcp"
+	c.WorkerCount = 1
+	c.TestCount = 6
+	c.LatencyLimit = 1000
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Join(listeners, "\n") + "\n127.0.0.0/24\n"
+	if err := os.WriteFile(c.IPFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu     sync.Mutex
+		probed = make(map[string]int)
+	)
+	run := func(c Config, limit int) []scanner.FinalResult {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		opts := c.ScanOptions()
+		opts.Prober = &countingProber{Prober: opts.Prober, limit: limit, cancel: cancel}
+		opts.OnResult = func(r scanner.FinalResult) {
+			mu.Lock()
+			probed[r.Addr()]++
+			mu.Unlock()
+		}
+		return RefineScan(ctx, c, SampleOptions{}, opts)
+	}
+
+	// 第一次扫描在第 4 个地址时中断，单个工人按计划顺序探测
+	SetSeed(1760612345)
+	first := run(c, 3)
+	if len(first) != 3 {
+		t.Fatalf("中断前找到 %d 个结果，期望 3 个", len(first))
+	}
+
+	cp, err := scanner.LoadCheckpoint(c.Checkpoint)
+	if err != nil {
+		t.Fatalf("中断后没有留下断点: %v", err)
+	}
+	if cp.Seed != 1760612345 || cp.Total() <= len(listeners) || len(cp.Results) != 3 {
+		t.Fatalf("断点: 种子 %d，计划 %d 个，结果 %d 个", cp.Seed, cp.Total(), len(cp.Results))
+	}
+	// 已探测的地址按 IP:端口 记录，被中断的第 4 个地址不算已探测
+	if got := strings.Join(cp.Done, ","); got != strings.Join(listeners[:3], ",") {
+		t.Fatalf("断点中的已探测地址 = %v，期望 %v", cp.Done, listeners[:3])
+	}
+	// 原子写入不会留下临时文件
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".*.tmp")); len(matches) != 0 {
+		t.Errorf("留下了临时文件 %v", matches)
+	}
+
+	// 从断点继续：恢复种子，不读取 IP 文件，只探测剩下的地址
+	SetSeed(1)
+	c.Resume = c.Checkpoint
+	if err := os.Remove(c.IPFile); err != nil {
+		t.Fatal(err)
+	}
+	second := run(c, 0)
+
+	if got := Seed(); got != 1760612345 {
+		t.Errorf("恢复后的种子为 %d，期望 1760612345", got)
+	}
+	for addr, n := range probed {
+		if n != 1 {
+			t.Errorf("%s 被探测了 %d 次", addr, n)
+		}
+	}
+	if len(probed) != cp.Total() {
+		t.Errorf("两次共探测 %d 个地址，期望 %d", len(probed), cp.Total())
+	}
+
+	// 结果包含中断前找到的结果，且每个监听地址只出现一次
+	var got []string
+	for _, r := range second {
+		if !r.Success() {
+			t.Errorf("结果 %s 未标记为成功", r.Addr())
+		}
+		got = append(got, r.Addr())
+	}
+	sort.Strings(got)
+	want := append([]string(nil), listeners...)
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("恢复后的结果 = %v，期望 %v", got, want)
+	}
+
+	// 扫描完成后删除断点文件
+	if _, err := os.Stat(c.Checkpoint); !os.IsNotExist(err) {
+		t.Errorf("扫描完成后断点文件仍存在: %v", err)
+	}
+}
@@ -0,0 +1,524 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gzjjjfree/cf-scanner/scanner"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix 环境变量前缀，例如 CF_SCANNER_WORKERS=200
+const envPrefix = "CF_SCANNER_"
+
+// loadLayers 按优先级合并配置：配置文件 → profile → 环境变量，
+// explicit 为命令行中显式指定过的参数名，这些参数保持不变（即命令行优先级最高）
+func loadLayers(c *Config, explicit map[string]bool) error {
+	// 未在命令行指定时，也允许通过环境变量指定配置文件和 profile
+	if c.ConfigFile == "" {
+		c.ConfigFile = os.Getenv(envPrefix + "CONFIG")
+	}
+	if c.Profile == "" {
+		c.Profile = os.Getenv(envPrefix + "PROFILE")
+	}
+
+	if c.ConfigFile != "" {
+		values, err := readConfigFile(c.ConfigFile)
+		if err != nil {
+			return err
+		}
+
+		profiles, err := takeProfiles(values, c.ConfigFile)
+		if err != nil {
+			return err
+		}
+		if err := applyValues(c, values, c.ConfigFile, explicit); err != nil {
+			return err
+		}
+
+		if c.Profile != "" {
+			profile, ok := profiles[c.Profile]
+			if !ok {
+				return fmt.Errorf("%s: 找不到 profile %q", c.ConfigFile, c.Profile)
+			}
+			if err := applyValues(c, profile, c.ConfigFile+" [profiles."+c.Profile+"]", explicit); err != nil {
+				return err
+			}
+		}
+	} else if c.Profile != "" {
+		return fmt.Errorf("指定了 profile %q 但没有配置文件 (-config)", c.Profile)
+	}
+
+	if err := applyEnv(c, explicit); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// readConfigFile 按扩展名解析 YAML / TOML / JSON 配置文件
+func readConfigFile(path string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	case ".json":
+		// 数字保留为 json.Number，避免大整数经过 float64 丢失精度
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	default:
+		return nil, fmt.Errorf("%s: 不支持的配置文件格式，请使用 .yaml/.yml/.toml/.json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return values, nil
+}
+
+// takeProfiles 从配置中取出 profiles 段（并从 values 中删除），返回 名字 → 配置项
+func takeProfiles(values map[string]interface{}, source string) (map[string]map[string]interface{}, error) {
+	raw, ok := values["profiles"]
+	if !ok {
+		return nil, nil
+	}
+	delete(values, "profiles")
+
+	table, ok := toStringMap(raw)
+	if !ok {
+		return nil, fmt.Errorf("%s: 配置项 \"profiles\" 必须是表", source)
+	}
+
+	profiles := make(map[string]map[string]interface{})
+	for name, v := range table {
+		profile, ok := toStringMap(v)
+		if !ok {
+			return nil, fmt.Errorf("%s: 配置项 \"profiles.%s\" 必须是表", source, name)
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+// toStringMap 将各种解析器得到的表统一为 map[string]interface{}
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			out[fmt.Sprint(k)] = val
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// applyEnv 读取 CF_SCANNER_<KEY> 形式的环境变量
+func applyEnv(c *Config, explicit map[string]bool) error {
+	values := make(map[string]interface{})
+	for _, f := range configFields() {
+		name := envPrefix + strings.ToUpper(f.key)
+		if v, ok := os.LookupEnv(name); ok {
+			values[f.key] = v
+		}
+	}
+	return applyValues(c, values, "环境变量", explicit)
+}
+
+// ApplyValues 将 key → 值 的配置项写入 Config，未知的 key 或类型不符时返回指明 key 的错误
+// 字符串值会按字段类型解析，便于处理环境变量和 HTTP 请求中的参数
+func ApplyValues(c *Config, values map[string]interface{}, source string) error {
+	return applyValues(c, values, source, nil)
+}
+
+// applyValues 同 ApplyValues，skipFlags 中的命令行参数已被显式指定，不会被覆盖
+func applyValues(c *Config, values map[string]interface{}, source string, skipFlags map[string]bool) error {
+	fields := make(map[string]configField)
+	for _, f := range configFields() {
+		fields[f.key] = f
+	}
+
+	// 按 key 排序，保证多个错误时报告的总是同一个
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rv := reflect.ValueOf(c).Elem()
+	for _, key := range keys {
+		f, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("%s: 未知配置项 %q", source, key)
+		}
+		if skipFlags[f.flag] {
+			continue
+		}
+		if err := setField(rv.Field(f.index), values[key]); err != nil {
+			return fmt.Errorf("%s: 配置项 %q %v", source, key, err)
+		}
+	}
+	return nil
+}
+
+// configField 描述一个可配置的 Config 字段
+type configField struct {
+	index int
+	key   string
+	flag  string
+}
+
+// configFields 按声明顺序列出 Config 中带 key 标签的字段
+func configFields() []configField {
+	t := reflect.TypeOf(Config{})
+	var fields []configField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if key := sf.Tag.Get("key"); key != "" {
+			fields = append(fields, configField{index: i, key: key, flag: sf.Tag.Get("flag")})
+		}
+	}
+	return fields
+}
+
+// setField 按字段类型转换并写入值
+func setField(field reflect.Value, v interface{}) error {
+	// 时长使用 "2s"、"500ms" 这样的字符串
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("需要时长字符串 (如 \"2s\")，实际为 %v", v)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("需要时长字符串 (如 \"2s\")，实际为 %q", s)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("需要字符串，实际为 %v", v)
+		}
+		field.SetString(s)
+
+	case reflect.Bool:
+		switch b := v.(type) {
+		case bool:
+			field.SetBool(b)
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return fmt.Errorf("需要布尔值，实际为 %q", b)
+			}
+			field.SetBool(parsed)
+		default:
+			return fmt.Errorf("需要布尔值，实际为 %v", v)
+		}
+
+	case reflect.Int, reflect.Int64:
+		n, ok := toInt(v)
+		if !ok || field.OverflowInt(n) {
+			return fmt.Errorf("需要整数，实际为 %v", v)
+		}
+		field.SetInt(n)
+
+	case reflect.Float64:
+		n, ok := toFloat(v)
+		if !ok {
+			return fmt.Errorf("需要数字，实际为 %v", v)
+		}
+		field.SetFloat(n)
+
+	default:
+		return fmt.Errorf("类型 %s 不支持配置", field.Kind())
+	}
+	return nil
+}
+
+// maxExactInt float64 能精确表示的最大整数 (2^53)
+const maxExactInt = 1 << 53
+
+// toInt 将解析器得到的各种数字类型（或数字字符串）转换为 int64，不经过 float64，
+// 大整数（如 -seed 的 UnixNano 种子）不会丢失精度；float64 只接受能精确表示的整数
+func toInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	case float64:
+		return int64(n), n == math.Trunc(n) && math.Abs(n) <= maxExactInt
+	case json.Number:
+		i, err := strconv.ParseInt(n.String(), 10, 64)
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// toFloat 将解析器得到的各种数字类型（或数字字符串）转换为 float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// ScanOptions 根据配置生成扫描阶段参数
+func (c Config) ScanOptions() scanner.ScanOptions {
+	opts := scanner.ScanOptions{
+		Domain:       c.Domain,
+		Scheme:       c.Scheme,
+		Timeout:      c.Timeout,
+		LatencyLimit: c.LatencyLimit,
+		Rounds:       c.Rounds,
+		MaxLoss:      c.MaxLoss,
+		SortBy:       c.SortBy,
+		Trace:        c.Trace,
+		KeepColos:    SplitList(c.KeepColos),
+		ExcludeColos: SplitList(c.ExcludeColos),
+		Scorer:       c.scorer(),
+	}
+	// 配置校验时已确认探测方式存在
+	// 明文 HTTP 没有 TLS 握手，tls 探测改为发送 HTTP 请求并等待响应头
+	probe := c.Probe
+	if c.Scheme == "http" && probe == "tls" {
+		probe = "http"
+	}
+	opts.Prober, _ = scanner.NewProber(probe, opts)
+	return opts
+}
+
+// DeepTestOptions 根据配置生成下载测速阶段参数
+func (c Config) DeepTestOptions() scanner.DeepTestOptions {
+	return scanner.DeepTestOptions{
+		OutCount:    c.OutCount,
+		Domain:      c.Domain,
+		Scheme:      c.Scheme,
+		HTTPVersion: c.HTTPVersion,
+		MinSpeed:    c.MinSpeed,
+		Duration:    5 * time.Second,
+		Conns:       c.Conns,
+		Upload:      c.Upload,
+		MinUpload:   c.MinUpload,
+		SortBy:      c.SpeedSort,
+		Scorer:      c.scorer(),
+
+		Concurrency: c.DeepWorkers,
+		LinkMbps:    c.LinkMbps,
+	}
+}
+
+// WSOptions 根据配置生成 WebSocket 升级验证参数，只验证进入测速的前 OutCount*2 个候选
+func (c Config) WSOptions() scanner.WSOptions {
+	return scanner.WSOptions{
+		Domain:      c.Domain,
+		Host:        c.WSHost,
+		Path:        c.WSPath,
+		Scheme:      c.Scheme,
+		Timeout:     c.Timeout,
+		Concurrency: c.WorkerCount,
+		Limit:       c.OutCount * 2,
+	}
+}
+
+// Validate 检查配置取值，错误信息中带上出错的配置项
+func (c Config) Validate() error {
+	switch {
+	case c.Command != "" && c.Command != "serve" && c.Command != "history" && c.Command != "plan":
+		return fmt.Errorf("未知的子命令 %q，可选: serve/history/plan", c.Command)
+	case c.Domain == "":
+		return fmt.Errorf("配置项 \"domain\" 不能为空")
+	case c.Scheme != "https" && c.Scheme != "http":
+		return fmt.Errorf("配置项 \"scheme\" 只能是 https/http，实际为 %q", c.Scheme)
+	case c.IPFile == "":
+		return fmt.Errorf("配置项 \"ip_file\" 不能为空")
+	case validPorts(c.Ports) != nil:
+		return fmt.Errorf("配置项 \"ports\" %v", validPorts(c.Ports))
+	case c.Resolver != "" && resolverAddr(c.Resolver) == "":
+		return fmt.Errorf("配置项 \"resolver\" 应为 IP 或 IP:端口，实际为 %q", c.Resolver)
+	case c.WorkerCount < 1:
+		return fmt.Errorf("配置项 \"workers\" 必须大于 0，实际为 %d", c.WorkerCount)
+	case c.LatencyLimit < 1:
+		return fmt.Errorf("配置项 \"latency\" 必须大于 0，实际为 %d", c.LatencyLimit)
+	case c.Timeout <= 0:
+		return fmt.Errorf("配置项 \"timeout\" 必须大于 0，实际为 %v", c.Timeout)
+	case !validProbe(c.Probe):
+		return fmt.Errorf("配置项 \"probe\" 只能是 %s，实际为 %q", strings.Join(scanner.ProberNames(), "/"), c.Probe)
+	case c.Probe == "quic" && c.Scheme == "http":
+		return fmt.Errorf("配置项 \"probe\" 为 quic 时 \"scheme\" 必须是 https")
+	case c.Rounds < 1:
+		return fmt.Errorf("配置项 \"rounds\" 必须大于 0，实际为 %d", c.Rounds)
+	case c.MaxLoss < 0 || c.MaxLoss > 100:
+		return fmt.Errorf("配置项 \"max_loss\" 必须在 0~100 之间，实际为 %v", c.MaxLoss)
+	case c.SortBy != "latency" && c.SortBy != "loss" && c.SortBy != "jitter" && c.SortBy != "p95":
+		return fmt.Errorf("配置项 \"sort\" 只能是 latency/loss/jitter/p95，实际为 %q", c.SortBy)
+	case c.WSPath != "" && !strings.HasPrefix(c.WSPath, "/"):
+		return fmt.Errorf("配置项 \"ws_path\" 必须以 / 开头，实际为 %q", c.WSPath)
+	case c.HTTPVersion != "1.1" && c.HTTPVersion != "2" && c.HTTPVersion != "3":
+		return fmt.Errorf("配置项 \"http_version\" 只能是 1.1/2/3，实际为 %q", c.HTTPVersion)
+	case c.HTTPVersion != "1.1" && c.Scheme == "http":
+		return fmt.Errorf("配置项 \"http_version\" 为 %s 时 \"scheme\" 必须是 https", c.HTTPVersion)
+	case c.MinUpload < 0:
+		return fmt.Errorf("配置项 \"min_upload\" 不能为负数，实际为 %v", c.MinUpload)
+	case c.MinUpload > 0 && c.Upload == "":
+		return fmt.Errorf("配置项 \"min_upload\" 需要同时指定 \"upload\"")
+	case c.SpeedSort != "download" && c.SpeedSort != "upload" && c.SpeedSort != "total" && c.SpeedSort != "stable":
+		return fmt.Errorf("配置项 \"speed_sort\" 只能是 download/upload/total/stable，实际为 %q", c.SpeedSort)
+	case (c.SpeedSort == "upload" || c.SpeedSort == "total") && c.Upload == "":
+		return fmt.Errorf("配置项 \"speed_sort\" 为 %s 时需要同时指定 \"upload\"", c.SpeedSort)
+	case validScore(c.Score) != nil:
+		return fmt.Errorf("配置项 \"score\" %v", validScore(c.Score))
+	case c.Conns < 1:
+		return fmt.Errorf("配置项 \"conns\" 必须大于 0，实际为 %d", c.Conns)
+	case c.DeepWorkers < 1:
+		return fmt.Errorf("配置项 \"deep_workers\" 必须大于 0，实际为 %d", c.DeepWorkers)
+	case c.LinkMbps < 0:
+		return fmt.Errorf("配置项 \"link_mbps\" 不能为负数，实际为 %v", c.LinkMbps)
+	case c.OutCount < 1:
+		return fmt.Errorf("配置项 \"out_count\" 必须大于 0，实际为 %d", c.OutCount)
+	case c.TestCount < 1:
+		return fmt.Errorf("配置项 \"test_count\" 必须大于 0，实际为 %d", c.TestCount)
+	case c.Refine < 1:
+		return fmt.Errorf("配置项 \"refine\" 必须大于 0，实际为 %d", c.Refine)
+	case c.Resume != "" && c.Refine > 1:
+		return fmt.Errorf("配置项 \"resume\" 暂不支持与 \"refine\" 同时使用")
+	case c.Checkpoint != "" && c.Refine > 1:
+		return fmt.Errorf("配置项 \"checkpoint\" 暂不支持与 \"refine\" 同时使用")
+	case c.BlockTTL <= 0:
+		return fmt.Errorf("配置项 \"block_ttl\" 必须大于 0，实际为 %v", c.BlockTTL)
+	case c.Interval <= 0:
+		return fmt.Errorf("配置项 \"interval\" 必须大于 0，实际为 %v", c.Interval)
+	case c.Recheck < 0:
+		return fmt.Errorf("配置项 \"recheck\" 不能为负数，实际为 %v", c.Recheck)
+	case c.HistoryRank && c.History == "":
+		return fmt.Errorf("配置项 \"history_rank\" 需要同时指定 \"history\"")
+	case c.HistoryDays < 1:
+		return fmt.Errorf("配置项 \"history_days\" 必须大于 0，实际为 %d", c.HistoryDays)
+	case c.Command == "history" && c.History == "":
+		return fmt.Errorf("history 子命令需要指定 \"history\" 数据库文件")
+	case c.Command == "history" && len(c.Args) != 1:
+		return fmt.Errorf("history 子命令需要一个 IP 或网段参数，如 history 104.16.0.1 或 history 104.16.0.0/24")
+	case c.Command == "plan" && len(c.Args) > 1:
+		return fmt.Errorf("plan 子命令最多一个参数（输出文件），实际为 %d 个", len(c.Args))
+	}
+
+	formats, err := ParseFormats(c.Formats)
+	if err != nil {
+		return fmt.Errorf("配置项 \"format\" %v", err)
+	}
+	for _, f := range formats {
+		if (f == "clash" || f == "singbox") && c.Template == "" {
+			return fmt.Errorf("配置项 \"format\" 包含 %s 时必须指定 \"template\"", f)
+		}
+		if f == "xray" && c.XrayTemplate == "" {
+			return fmt.Errorf("配置项 \"format\" 包含 xray 时必须指定 \"xray_template\"")
+		}
+	}
+	return nil
+}
+
+// scorer 返回 -score 对应的综合得分，未配置时为 nil（配置校验时已确认格式正确）
+func (c Config) scorer() *scanner.Scorer {
+	if c.Score == "" {
+		return nil
+	}
+	scorer, _ := scanner.ParseScorer(c.Score)
+	return scorer
+}
+
+// validScore 检查综合得分的格式
+func validScore(s string) error {
+	if s == "" {
+		return nil
+	}
+	_, err := scanner.ParseScorer(s)
+	return err
+}
+
+// scanPorts 返回每个 IP 要探测的端口，未指定 -ports 时 http 探测 80 端口，https 为 nil（即 443）
+func (c Config) scanPorts() []int {
+	ports, _ := ParsePorts(c.Ports)
+	if len(ports) == 0 && c.Scheme == "http" {
+		return []int{80}
+	}
+	return ports
+}
+
+// validPorts 检查端口列表的格式
+func validPorts(s string) error {
+	_, err := ParsePorts(s)
+	return err
+}
+
+// validProbe 判断探测方式是否已注册
+func validProbe(name string) bool {
+	for _, n := range scanner.ProberNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintConfig 以 YAML 格式输出合并后的配置，输出内容可直接作为配置文件使用
+func PrintConfig(w io.Writer, c Config) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	rv := reflect.ValueOf(c)
+	for _, f := range configFields() {
+		v := rv.Field(f.index).Interface()
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		value := &yaml.Node{}
+		if err := value.Encode(v); err != nil {
+			return err
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.key}, value)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bigSeed 19 位的种子（UnixNano 量级），超过 float64 能精确表示的范围
+const bigSeed int64 = 1760612345678901237
+
+// writeFile 在临时目录中写入文件并返回路径
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSeedRoundTrip(t *testing.T) {
+	files := map[string]string{
+		"seed.yaml": "seed: 1760612345678901237\n",
+		"seed.toml": "seed = 1760612345678901237\n",
+		"seed.json": `{"seed": 1760612345678901237}`,
+	}
+	for name, content := range files {
+		values, err := readConfigFile(writeFile(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var c Config
+		if err := ApplyValues(&c, values, name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if c.Seed != bigSeed {
+			t.Errorf("%s: seed = %d，期望 %d", name, c.Seed, bigSeed)
+		}
+	}
+
+	t.Setenv(envPrefix+"SEED", "1760612345678901237")
+	var c Config
+	if err := applyEnv(&c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.Seed != bigSeed {
+		t.Errorf("环境变量: seed = %d，期望 %d", c.Seed, bigSeed)
+	}
+}
+
+func TestSetFieldInt(t *testing.T) {
+	tests := []struct {
+		v  interface{}
+		ok bool
+	}{
+		{200, true},
+		{int64(200), true},
+		{uint64(200), true},
+		{float64(200), true},
+		{"200", true},
+		{" 200 ", true},
+		{float64(1.5), false},
+		{float64(1 << 60), false}, // 超过 2^53 的 float64 可能已经被舍入
+		{uint64(1) << 63, false},
+		{"1.5", false},
+		{"abc", false},
+		{true, false},
+	}
+	for _, tt := range tests {
+		var c Config
+		err := ApplyValues(&c, map[string]interface{}{"workers": tt.v}, "测试")
+		if (err == nil) != tt.ok {
+			t.Errorf("workers = %#v: 错误 %v", tt.v, err)
+		}
+	}
+}
+
+// testDefaults 返回与命令行默认值相同、能通过校验的配置
+func testDefaults() Config {
+	return Config{
+		Domain:       "speed.cloudflare.com/__down?bytes=100000000",
+		Scheme:       "https",
+		IPFile:       "ip.txt",
+		WorkerCount:  200,
+		LatencyLimit: 300,
+		Probe:        "tls",
+		Timeout:      time.Second,
+		Rounds:       1,
+		SortBy:       "latency",
+		HTTPVersion:  "1.1",
+		SpeedSort:    "download",
+		Conns:        1,
+		DeepWorkers:  1,
+		OutCount:     10,
+		TestCount:    100,
+		Refine:       1,
+		BlockTTL:     24 * time.Hour,
+		Formats:      "csv,json",
+		Interval:     6 * time.Hour,
+		HistoryDays:  7,
+	}
+}
+
+// sameConfig 三种格式的同一份配置，profile fast 覆盖 workers 和 timeout
+var sameConfig = map[string]string{
+	"conf.yaml": `
+workers: 100
+latency: 500
+colo: HKG
+trace: true
+min_speed: 12.5
+timeout: 2s
+profiles:
+  fast:
+    workers: 300
+    timeout: 500ms
+`,
+	"conf.toml": `
+workers = 100
+latency = 500
+colo = "HKG"
+trace = true
+min_speed = 12.5
+timeout = "2s"
+
+[profiles.fast]
+workers = 300
+timeout = "500ms"
+`,
+	"conf.json": `{
+	"workers": 100,
+	"latency": 500,
+	"colo": "HKG",
+	"trace": true,
+	"min_speed": 12.5,
+	"timeout": "2s",
+	"profiles": {"fast": {"workers": 300, "timeout": "500ms"}}
+}`,
+}
+
+func TestLoadLayersFormats(t *testing.T) {
+	var first *Config
+	for name, content := range sameConfig {
+		for _, profile := range []string{"", "fast"} {
+			c := testDefaults()
+			c.ConfigFile = writeFile(t, name, content)
+			c.Profile = profile
+			if err := loadLayers(&c, nil); err != nil {
+				t.Fatalf("%s [%s]: %v", name, profile, err)
+			}
+
+			workers, timeout := 100, 2*time.Second
+			if profile == "fast" {
+				workers, timeout = 300, 500*time.Millisecond
+			}
+			if c.WorkerCount != workers || c.Timeout != timeout || c.LatencyLimit != 500 ||
+				c.KeepColos != "HKG" || !c.Trace || c.MinSpeed != 12.5 {
+				t.Errorf("%s [%s]: 合并结果 %+v", name, profile, c)
+			}
+			if profile == "" {
+				c.ConfigFile = ""
+				if first == nil {
+					first = &c
+				} else if !reflect.DeepEqual(c, *first) {
+					t.Errorf("%s 与其他格式的解析结果不同", name)
+				}
+			}
+		}
+	}
+}
+
+func TestLoadLayersPrecedence(t *testing.T) {
+	t.Setenv(envPrefix+"LATENCY", "200")
+	t.Setenv(envPrefix+"WORKERS", "150")
+
+	// 配置文件 → profile → 环境变量 → 命令行，后者覆盖前者
+	c := testDefaults()
+	c.ConfigFile = writeFile(t, "conf.yaml", sameConfig["conf.yaml"])
+	c.Profile = "fast"
+	c.WorkerCount = 50 // 模拟命令行 -n 50
+	if err := loadLayers(&c, map[string]bool{"n": true}); err != nil {
+		t.Fatal(err)
+	}
+	if c.WorkerCount != 50 {
+		t.Errorf("workers = %d，命令行参数应优先", c.WorkerCount)
+	}
+	if c.LatencyLimit != 200 {
+		t.Errorf("latency = %d，环境变量应覆盖配置文件", c.LatencyLimit)
+	}
+	if c.Timeout != 500*time.Millisecond {
+		t.Errorf("timeout = %v，profile 应覆盖配置文件", c.Timeout)
+	}
+	if c.KeepColos != "HKG" {
+		t.Errorf("colo = %q，应来自配置文件", c.KeepColos)
+	}
+
+	// 没有命令行参数时环境变量覆盖 profile
+	c = testDefaults()
+	c.ConfigFile = writeFile(t, "conf.yaml", sameConfig["conf.yaml"])
+	c.Profile = "fast"
+	if err := loadLayers(&c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.WorkerCount != 150 {
+		t.Errorf("workers = %d，环境变量应覆盖 profile", c.WorkerCount)
+	}
+}
+
+func TestLoadLayersErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		profile string
+		want    string // 错误信息中应包含的内容
+	}{
+		{"conf.yaml", "workers: 0\n", "", `"workers"`},
+		{"conf.yaml", "wokers: 10\n", "", `"wokers"`},
+		{"conf.yaml", "timeout: 5\n", "", `"timeout"`},
+		{"conf.toml", "sort = \"speed\"\n", "", `"sort"`},
+		{"conf.json", `{"rounds": 1.5}`, "", `"rounds"`},
+		{"conf.json", `{"profiles": {"a": {"max_loss": 200}}}`, "a", `"max_loss"`},
+		{"conf.yaml", "workers: 10\n", "missing", `"missing"`},
+		{"conf.ini", "workers = 10\n", "", "不支持"},
+	}
+	for _, tt := range tests {
+		c := testDefaults()
+		c.ConfigFile = writeFile(t, tt.name, tt.content)
+		c.Profile = tt.profile
+		err := loadLayers(&c, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %q: 错误应包含 %s，实际为 %v", tt.name, tt.content, tt.want, err)
+		}
+	}
+
+	t.Setenv(envPrefix+"WORKERS", "many")
+	c := testDefaults()
+	if err := loadLayers(&c, nil); err == nil || !strings.Contains(err.Error(), `"workers"`) {
+		t.Errorf("环境变量取值错误时应指明配置项，实际为 %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+	"gopkg.in/yaml.v3"
+)
+
+// 支持的输出格式
+// csv / json 为原有的详细报告和地址列表，v2ray 是 json 的别名，xray 为完整的 Xray 客户端配置
+var knownFormats = map[string]bool{
+	"csv":     true,
+	"json":    true,
+	"v2ray":   true,
+	"clash":   true,
+	"singbox": true,
+	"xray":    true,
+}
+
+// NodeTemplate 节点模板，由 -template 指定的 YAML / JSON 文件提供
+// 导出时每个优选 IP 生成一个节点，只替换服务器地址和节点名
+type NodeTemplate struct {
+	Type        string `yaml:"type" json:"type"`               // vless / vmess / trojan
+	UUID        string `yaml:"uuid" json:"uuid"`               // vless / vmess 的用户 ID
+	Password    string `yaml:"password" json:"password"`       // trojan 密码
+	AlterID     int    `yaml:"alter_id" json:"alter_id"`       // vmess alterId，一般为 0
+	Cipher      string `yaml:"cipher" json:"cipher"`           // vmess 加密方式，默认 auto
+	Port        int    `yaml:"port" json:"port"`               // 服务端口，默认 443
+	Network     string `yaml:"network" json:"network"`         // 传输方式，默认 ws
+	Path        string `yaml:"path" json:"path"`               // ws 路径
+	Host        string `yaml:"host" json:"host"`               // ws Host 头
+	TLS         *bool  `yaml:"tls" json:"tls"`                 // 是否启用 TLS，默认 true
+	SNI         string `yaml:"sni" json:"sni"`                 // TLS SNI，默认同 host
+	Insecure    bool   `yaml:"insecure" json:"insecure"`       // 跳过证书校验
+	Fingerprint string `yaml:"fingerprint" json:"fingerprint"` // uTLS 指纹，如 chrome
+	UDP         bool   `yaml:"udp" json:"udp"`                 // Clash 中是否允许 UDP
+}
+
+// LoadNodeTemplate 读取节点模板并补全默认值
+func LoadNodeTemplate(path string) (*NodeTemplate, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl := &NodeTemplate{}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(content, tpl)
+	} else {
+		err = yaml.Unmarshal(content, tpl)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	tpl.Type = strings.ToLower(tpl.Type)
+	switch tpl.Type {
+	case "vless", "vmess":
+		if tpl.UUID == "" {
+			return nil, fmt.Errorf("%s: %s 节点需要 uuid", path, tpl.Type)
+		}
+	case "trojan":
+		if tpl.Password == "" {
+			return nil, fmt.Errorf("%s: trojan 节点需要 password", path)
+		}
+	default:
+		return nil, fmt.Errorf("%s: 不支持的节点类型 %q，可选 vless/vmess/trojan", path, tpl.Type)
+	}
+
+	if tpl.Port == 0 {
+		tpl.Port = 443
+	}
+	if tpl.Network == "" {
+		tpl.Network = "ws"
+	}
+	if tpl.Path == "" {
+		tpl.Path = "/"
+	}
+	if tpl.Cipher == "" {
+		tpl.Cipher = "auto"
+	}
+	if tpl.TLS == nil {
+		enabled := true
+		tpl.TLS = &enabled
+	}
+	if tpl.SNI == "" {
+		tpl.SNI = tpl.Host
+	}
+	return tpl, nil
+}
+
+// nodePort 节点端口：结果使用了非 443 端口（-ports 或输入中指定）时以扫描的端口为准，否则使用模板中的端口
+func nodePort(tpl *NodeTemplate, r scanner.FinalResult) int {
+	if r.Port != 0 && r.Port != scanner.DefaultPort {
+		return r.Port
+	}
+	return tpl.Port
+}
+
+// nodeName 按 排名-数据中心-速度 生成节点名，如 01-HKG-52.3Mbps
+func nodeName(rank int, r scanner.FinalResult) string {
+	parts := []string{fmt.Sprintf("%02d", rank)}
+	if r.Colo != "" {
+		parts = append(parts, r.Colo)
+	}
+	parts = append(parts, fmt.Sprintf("%.1fMbps", r.DownloadMBs))
+	return strings.Join(parts, "-")
+}
+
+// clashProxy Clash / Mihomo 的 proxies 条目，字段顺序即输出顺序
+type clashProxy struct {
+	Name              string            `yaml:"name"`
+	Type              string            `yaml:"type"`
+	Server            string            `yaml:"server"`
+	Port              int               `yaml:"port"`
+	UUID              string            `yaml:"uuid,omitempty"`
+	Password          string            `yaml:"password,omitempty"`
+	AlterID           *int              `yaml:"alterId,omitempty"`
+	Cipher            string            `yaml:"cipher,omitempty"`
+	UDP               bool              `yaml:"udp"`
+	TLS               bool              `yaml:"tls,omitempty"`
+	ServerName        string            `yaml:"servername,omitempty"`
+	SNI               string            `yaml:"sni,omitempty"`
+	SkipCertVerify    bool              `yaml:"skip-cert-verify"`
+	ClientFingerprint string            `yaml:"client-fingerprint,omitempty"`
+	Network           string            `yaml:"network"`
+	WSOpts            *clashWSOpts      `yaml:"ws-opts,omitempty"`
+	GRPCOpts          map[string]string `yaml:"grpc-opts,omitempty"`
+}
+
+type clashWSOpts struct {
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// SaveToClash 生成 Clash / Mihomo 的 proxies 配置（可直接作为 proxy-provider 使用）
+func SaveToClash(filename string, tpl *NodeTemplate, data []scanner.FinalResult) error {
+	var proxies []clashProxy
+	for i, r := range data {
+		p := clashProxy{
+			Name:              nodeName(i+1, r),
+			Type:              tpl.Type,
+			Server:            r.IP,
+			Port:              nodePort(tpl, r),
+			UDP:               tpl.UDP,
+			TLS:               *tpl.TLS,
+			SkipCertVerify:    tpl.Insecure,
+			ClientFingerprint: tpl.Fingerprint,
+			Network:           tpl.Network,
+		}
+
+		switch tpl.Type {
+		case "vless":
+			p.UUID = tpl.UUID
+		case "vmess":
+			p.UUID = tpl.UUID
+			p.AlterID = &tpl.AlterID
+			p.Cipher = tpl.Cipher
+		case "trojan":
+			p.Password = tpl.Password
+		}
+
+		// trojan 使用 sni，其他类型使用 servername
+		if *tpl.TLS {
+			if tpl.Type == "trojan" {
+				p.SNI = tpl.SNI
+			} else {
+				p.ServerName = tpl.SNI
+			}
+		}
+
+		switch tpl.Network {
+		case "ws":
+			p.WSOpts = &clashWSOpts{Path: tpl.Path}
+			if tpl.Host != "" {
+				p.WSOpts.Headers = map[string]string{"Host": tpl.Host}
+			}
+		case "grpc":
+			p.GRPCOpts = map[string]string{"grpc-service-name": strings.TrimPrefix(tpl.Path, "/")}
+		}
+		proxies = append(proxies, p)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(map[string][]clashProxy{"proxies": proxies}); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, buf.Bytes())
+}
+
+// SaveToSingBox 生成 sing-box 的 outbounds 配置
+func SaveToSingBox(filename string, tpl *NodeTemplate, data []scanner.FinalResult) error {
+	var outbounds []map[string]interface{}
+	for i, r := range data {
+		out := map[string]interface{}{
+			"type":        tpl.Type,
+			"tag":         nodeName(i+1, r),
+			"server":      r.IP,
+			"server_port": nodePort(tpl, r),
+		}
+
+		switch tpl.Type {
+		case "vless":
+			out["uuid"] = tpl.UUID
+		case "vmess":
+			out["uuid"] = tpl.UUID
+			out["alter_id"] = tpl.AlterID
+			out["security"] = tpl.Cipher
+		case "trojan":
+			out["password"] = tpl.Password
+		}
+
+		if *tpl.TLS {
+			tlsConf := map[string]interface{}{
+				"enabled":     true,
+				"server_name": tpl.SNI,
+				"insecure":    tpl.Insecure,
+			}
+			if tpl.Fingerprint != "" {
+				tlsConf["utls"] = map[string]interface{}{"enabled": true, "fingerprint": tpl.Fingerprint}
+			}
+			out["tls"] = tlsConf
+		}
+
+		switch tpl.Network {
+		case "ws":
+			transport := map[string]interface{}{"type": "ws", "path": tpl.Path}
+			if tpl.Host != "" {
+				transport["headers"] = map[string]string{"Host": tpl.Host}
+			}
+			out["transport"] = transport
+		case "grpc":
+			out["transport"] = map[string]interface{}{"type": "grpc", "service_name": strings.TrimPrefix(tpl.Path, "/")}
+		}
+		outbounds = append(outbounds, out)
+	}
+
+	content, err := json.MarshalIndent(map[string]interface{}{"outbounds": outbounds}, "", "    ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, content)
+}
+
+// ParseFormats 解析 -format 参数，返回去重后的小写格式列表
+func ParseFormats(s string) ([]string, error) {
+	seen := make(map[string]bool)
+	var formats []string
+	for _, f := range SplitList(s) {
+		f = strings.ToLower(f)
+		if f == "v2ray" {
+			f = "json"
+		}
+		if !knownFormats[f] {
+			return nil, fmt.Errorf("未知的输出格式 %q，可选 csv/json/v2ray/clash/singbox/xray", f)
+		}
+		if !seen[f] {
+			seen[f] = true
+			formats = append(formats, f)
+		}
+	}
+	return formats, nil
+}
+
+// SaveOutputs 按 -format 指定的格式保存结果，返回写入的文件列表
+func SaveOutputs(c Config, data []scanner.FinalResult) ([]string, error) {
+	formats, err := ParseFormats(c.Formats)
+	if err != nil {
+		return nil, err
+	}
+
+	var tpl *NodeTemplate
+	var files []string
+	for _, f := range formats {
+		switch f {
+		case "csv":
+			if err := SaveToCSV(c.OutFile+".csv", data); err != nil {
+				return files, err
+			}
+			files = append(files, c.OutFile+".csv")
+		case "json":
+			if err := SaveToJSON(c.OutFile+".json", data); err != nil {
+				return files, err
+			}
+			files = append(files, c.OutFile+".json")
+		case "xray":
+			if err := SaveToXray(c.OutFile+".xray.json", c.XrayTemplate, data); err != nil {
+				return files, err
+			}
+			files = append(files, c.OutFile+".xray.json")
+		case "clash", "singbox":
+			if tpl == nil {
+				if tpl, err = LoadNodeTemplate(c.Template); err != nil {
+					return files, err
+				}
+			}
+			name := c.OutFile + ".clash.yaml"
+			save := SaveToClash
+			if f == "singbox" {
+				name = c.OutFile + ".singbox.json"
+				save = SaveToSingBox
+			}
+			if err := save(name, tpl, data); err != nil {
+				return files, err
+			}
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+	"gopkg.in/yaml.v3"
+)
+
+// exportResults 导出用的结果：443 端口使用模板端口，其他端口以扫描的端口为准
+var exportResults = []scanner.FinalResult{
+	{IP: "1.1.1.1", Port: 443, Colo: "HKG", DownloadMBs: 52.34},
+	{IP: "2606:4700::1", Port: 8443, DownloadMBs: 10},
+	{IP: "1.0.0.1", Colo: "NRT"},
+}
+
+var (
+	exportNames = []string{"01-HKG-52.3Mbps", "02-10.0Mbps", "03-NRT-0.0Mbps"}
+	exportPorts = []int{2053, 8443, 2053}
+)
+
+// loadTemplate 写入并读取节点模板
+func loadTemplate(t *testing.T, name, content string) *NodeTemplate {
+	t.Helper()
+	tpl, err := LoadNodeTemplate(writeFile(t, name, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tpl
+}
+
+const vlessTemplate = `
+type: VLESS
+uuid: 11111111-2222-3333-4444-555555555555
+port: 2053
+path: /ws?ed=2048
+host: cdn.example.com
+fingerprint: chrome
+udp: true
+`
+
+func TestSaveToClashGolden(t *testing.T) {
+	tpl := loadTemplate(t, "node.yaml", vlessTemplate)
+	out := filepath.Join(t.TempDir(), "result.clash.yaml")
+	if err := SaveToClash(out, tpl, exportResults[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `proxies:
+  - name: 01-HKG-52.3Mbps
+    type: vless
+    server: 1.1.1.1
+    port: 2053
+    uuid: 11111111-2222-3333-4444-555555555555
+    udp: true
+    tls: true
+    servername: cdn.example.com
+    skip-cert-verify: false
+    client-fingerprint: chrome
+    network: ws
+    ws-opts:
+      path: /ws?ed=2048
+      headers:
+        Host: cdn.example.com
+`
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != want {
+		t.Errorf("Clash 输出:\n%s\n期望:\n%s", content, want)
+	}
+}
+
+func TestSaveToClash(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		check    func(p map[string]interface{}) error
+	}{
+		{"vmess", `{"type": "vmess", "uuid": "u", "port": 2053, "tls": false}`, func(p map[string]interface{}) error {
+			if p["uuid"] != "u" || p["alterId"] != 0 || p["cipher"] != "auto" || p["tls"] != nil || p["servername"] != nil {
+				return fmt.Errorf("vmess 字段错误")
+			}
+			return nil
+		}},
+		{"trojan", "type: trojan\npassword: secret\nport: 2053\nnetwork: grpc\npath: /tunnel\nsni: sni.example.com\n", func(p map[string]interface{}) error {
+			grpc, _ := p["grpc-opts"].(map[string]interface{})
+			if p["password"] != "secret" || p["sni"] != "sni.example.com" || p["servername"] != nil || grpc["grpc-service-name"] != "tunnel" {
+				return fmt.Errorf("trojan 字段错误")
+			}
+			return nil
+		}},
+	}
+	for _, tt := range tests {
+		ext := ".yaml"
+		if strings.HasPrefix(tt.template, "{") {
+			ext = ".json"
+		}
+		tpl := loadTemplate(t, "node"+ext, tt.template)
+		out := filepath.Join(t.TempDir(), "result.clash.yaml")
+		if err := SaveToClash(out, tpl, exportResults); err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed struct {
+			Proxies []map[string]interface{} `yaml:"proxies"`
+		}
+		if err := yaml.Unmarshal(content, &parsed); err != nil {
+			t.Fatalf("%s: 无法解析 Clash 输出: %v", tt.name, err)
+		}
+		if len(parsed.Proxies) != len(exportResults) {
+			t.Fatalf("%s: 输出 %d 个节点，期望 %d", tt.name, len(parsed.Proxies), len(exportResults))
+		}
+		for i, p := range parsed.Proxies {
+			if p["name"] != exportNames[i] || p["server"] != exportResults[i].IP || p["port"] != exportPorts[i] || p["type"] != tt.name {
+				t.Errorf("%s: 第 %d 个节点 = %v", tt.name, i+1, p)
+			}
+			if err := tt.check(p); err != nil {
+				t.Errorf("%s: 第 %d 个节点: %v: %v", tt.name, i+1, err, p)
+			}
+		}
+	}
+}
+
+func TestSaveToSingBox(t *testing.T) {
+	tpl := loadTemplate(t, "node.yaml", vlessTemplate)
+	out := filepath.Join(t.TempDir(), "result.singbox.json")
+	if err := SaveToSingBox(out, tpl, exportResults); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Outbounds []struct {
+			Type       string `json:"type"`
+			Tag        string `json:"tag"`
+			Server     string `json:"server"`
+			ServerPort int    `json:"server_port"`
+			UUID       string `json:"uuid"`
+			TLS        struct {
+				Enabled    bool   `json:"enabled"`
+				ServerName string `json:"server_name"`
+				UTLS       struct {
+					Fingerprint string `json:"fingerprint"`
+				} `json:"utls"`
+			} `json:"tls"`
+			Transport struct {
+				Type    string            `json:"type"`
+				Path    string            `json:"path"`
+				Headers map[string]string `json:"headers"`
+			} `json:"transport"`
+		} `json:"outbounds"`
+	}
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		t.Fatalf("无法解析 sing-box 输出: %v", err)
+	}
+	if len(parsed.Outbounds) != len(exportResults) {
+		t.Fatalf("输出 %d 个节点，期望 %d", len(parsed.Outbounds), len(exportResults))
+	}
+	for i, o := range parsed.Outbounds {
+		if o.Tag != exportNames[i] || o.Server != exportResults[i].IP || o.ServerPort != exportPorts[i] {
+			t.Errorf("第 %d 个节点: tag %q，地址 %s:%d", i+1, o.Tag, o.Server, o.ServerPort)
+		}
+		if o.Type != "vless" || o.UUID != tpl.UUID || !o.TLS.Enabled || o.TLS.ServerName != "cdn.example.com" || o.TLS.UTLS.Fingerprint != "chrome" {
+			t.Errorf("第 %d 个节点: %+v", i+1, o)
+		}
+		if o.Transport.Type != "ws" || o.Transport.Path != "/ws?ed=2048" || o.Transport.Headers["Host"] != "cdn.example.com" {
+			t.Errorf("第 %d 个节点传输配置: %+v", i+1, o.Transport)
+		}
+	}
+}
+
+func TestLoadNodeTemplateErrors(t *testing.T) {
+	tests := map[string]string{
+		"vless.yaml":  "type: vless\n",
+		"trojan.yaml": "type: trojan\n",
+		"ss.yaml":     "type: ss\npassword: x\n",
+		"bad.json":    "{",
+	}
+	for name, content := range tests {
+		if _, err := LoadNodeTemplate(writeFile(t, name, content)); err == nil {
+			t.Errorf("%s 应返回错误", name)
+		}
+	}
+}
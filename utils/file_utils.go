@@ -1,44 +1,89 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/gzjjjfree/cf-scanner/scanner"
 )
 
-// saveToCSV 保存详细报告
-func SaveToCSV(filename string, data []scanner.FinalResult) {
-	file, _ := os.Create(filename)
-	defer file.Close()
-	file.WriteString("\xEF\xBB\xBF") // 写入 UTF-8 BOM
+// WriteFileAtomic 先写入同目录下的临时文件再重命名，读取方不会看到写了一半的文件
+func WriteFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// 重命名成功后临时文件已不存在，Remove 只在出错时生效
+	defer os.Remove(tmp.Name())
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// saveToCSV 保存详细报告
+func SaveToCSV(filename string, data []scanner.FinalResult) error {
+	var buf bytes.Buffer
+	buf.WriteString("\xEF\xBB\xBF") // 写入 UTF-8 BOM
 
-	writer.Write([]string{"IP 地址", "延迟", "下载速度", "时间"})
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"IP 地址", "数据中心", "延迟", "P95 延迟", "抖动", "丢包率", "下载速度", "速度 P10", "速度波动", "卡顿次数", "聚合速度", "上传速度", "时间"})
 	for _, r := range data {
 		writer.Write([]string{
-			r.IP,
+			r.Addr(),
+			r.Colo,
 			r.Latency,
+			fmt.Sprintf("%dms", r.P95Latency),
+			fmt.Sprintf("%.1fms", r.Jitter),
+			fmt.Sprintf("%.0f%%", r.LossRate),
 			fmt.Sprintf("%.2f", r.DownloadMBs),
+			fmt.Sprintf("%.2f", r.SpeedP10),
+			fmt.Sprintf("%.2f", r.SpeedCV),
+			fmt.Sprintf("%d", r.Stalls),
+			fmt.Sprintf("%.2f", r.AggregateMBs),
+			fmt.Sprintf("%.2f", r.UploadMBs),
 			r.CreatedAt.Format("2006-01-02 15:04:05"), // Go 的标准时间格式化写法
 		})
 	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, buf.Bytes())
 }
 
 // saveToJSON 仅保存地址列表
-func SaveToJSON(filename string, data []scanner.FinalResult) {
-	file, _ := os.Create(filename)
-	defer file.Close()
+func SaveToJSON(filename string, data []scanner.FinalResult) error {
+	// JSON 里只保留 address（和 colo、scheme）字段，供 V2Ray / Worker 加载
+	// 非 443 端口的结果写为 ip:port
+	items := make([]IPItem, 0, len(data))
+	for _, r := range data {
+		items = append(items, IPItem{Address: r.Addr(), Colo: r.Colo, Scheme: r.Scheme})
+	}
 
-	// 如果你只需要 JSON 里显示 address 字段，
-	// FinalResult 里的其他字段在定义时加了 omitempty，且没有赋值时就会被隐藏
-	encoder := json.NewEncoder(file)
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
 	encoder.SetIndent("", "    ")
-	encoder.Encode(data)
+	if err := encoder.Encode(items); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, buf.Bytes())
 }
 
 // 追加保存到指定 JSON 文件
@@ -61,13 +106,19 @@ func AppendToJSONFile(path string, newResults []scanner.FinalResult) error {
 	for _, res := range newResults {
 		// 我们通过这种方式只提取带 json 标签的字段
 		item := map[string]interface{}{
-			"address": res.IP,
+			"address": res.Addr(),
+		}
+		if res.Colo != "" {
+			item["colo"] = res.Colo
+		}
+		if res.Scheme != "" {
+			item["scheme"] = res.Scheme
 		}
 
 		// 可选：在这里做去重逻辑
 		isDuplicate := false
 		for _, existing := range existingData {
-			if existing["address"] == res.IP {
+			if existing["address"] == res.Addr() {
 				isDuplicate = true
 				break
 			}
@@ -85,5 +136,29 @@ func AppendToJSONFile(path string, newResults []scanner.FinalResult) error {
 	}
 
 	// 覆盖写入文件
-	return os.WriteFile(path, updatedJSON, 0644)
+	return WriteFileAtomic(path, updatedJSON)
+}
+
+// SavePlan 将抽样计划写入文本文件，每行一个 IP，开头的注释记录种子和参数
+// 该文件可以直接作为 -f 的输入，按同样的 IP 重新扫描
+func SavePlan(filename string, c Config, ipGroups [][]string) error {
+	var buf bytes.Buffer
+	total := 0
+	for _, group := range ipGroups {
+		total += len(group)
+	}
+	fmt.Fprintf(&buf, "# cf-scanner 抽样计划\n")
+	fmt.Fprintf(&buf, "# seed=%d ip_file=%s test_count=%d\n", Seed(), c.IPFile, c.TestCount)
+	if c.Ports != "" {
+		fmt.Fprintf(&buf, "# ports=%s\n", c.Ports)
+	}
+	fmt.Fprintf(&buf, "# 共 %d 个 IP\n", total)
+
+	for _, group := range ipGroups {
+		for _, ip := range group {
+			buf.WriteString(ip)
+			buf.WriteByte('\n')
+		}
+	}
+	return WriteFileAtomic(filename, buf.Bytes())
 }
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+)
+
+func TestFinalResultJSON(t *testing.T) {
+	r := scanner.FinalResult{IP: "1.1.1.1", Port: 8443, Colo: "HKG", DownloadMBs: 52.3, RawLatency: 120, Latency: "120ms"}
+
+	// FinalResult 的 JSON 只有地址和数据中心
+	content, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != `{"address":"1.1.1.1","colo":"HKG"}` {
+		t.Errorf("FinalResult JSON = %s", content)
+	}
+
+	// 完整形式包含全部指标，可以还原
+	content, err = json.Marshal(scanner.ResultDetail(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back scanner.ResultDetail
+	if err := json.Unmarshal(content, &back); err != nil {
+		t.Fatal(err)
+	}
+	if got := scanner.FinalResult(back); got.Port != 8443 || got.DownloadMBs != 52.3 || got.RawLatency != 120 || got.Latency != "120ms" {
+		t.Errorf("ResultDetail JSON = %s，还原后为 %+v", content, got)
+	}
+}
+
+func TestSaveToCSVHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.csv")
+	data := []scanner.FinalResult{{IP: "1.1.1.1", Port: 8443, Colo: "HKG", Latency: "120ms"}}
+	if err := SaveToCSV(path, data); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimPrefix(string(content), "\xEF\xBB\xBF"), "\n")
+	if !strings.HasPrefix(lines[0], "IP 地址,数据中心,延迟,") {
+		t.Errorf("CSV 表头 = %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "1.1.1.1:8443,HKG,120ms,") {
+		t.Errorf("CSV 第一行 = %q", lines[1])
+	}
+}
@@ -2,22 +2,74 @@ package utils
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
 )
 
 // 辅助结构体，用于在包之间传递参数
+// key 标签为配置文件、环境变量中使用的名字，flag 标签为对应的命令行参数
 type Config struct {
-	Domain         string
-	IPFile         string
-	OutFile        string
-	WorkerCount    int
-	LatencyLimit   int64
-	MinSpeed       float64
-	OutCount       int
-	TestCount      int
-	AppendMode     bool
-	OutputFilePath string
-	ShowVersion    bool
-	Help           bool
+	Domain         string        `key:"domain" flag:"d"`
+	Scheme         string        `key:"scheme" flag:"scheme"`
+	IPFile         string        `key:"ip_file" flag:"f"`
+	Resolver       string        `key:"resolver" flag:"resolver"`
+	Ports          string        `key:"ports" flag:"ports"`
+	BestPort       bool          `key:"best_port" flag:"best-port"`
+	OutFile        string        `key:"out_file" flag:"o"`
+	WorkerCount    int           `key:"workers" flag:"n"`
+	LatencyLimit   int64         `key:"latency" flag:"l"`
+	Probe          string        `key:"probe" flag:"probe"`
+	Timeout        time.Duration `key:"timeout" flag:"timeout"`
+	Rounds         int           `key:"rounds" flag:"r"`
+	MaxLoss        float64       `key:"max_loss" flag:"loss"`
+	SortBy         string        `key:"sort" flag:"sort"`
+	Trace          bool          `key:"trace" flag:"trace"`
+	KeepColos      string        `key:"colo" flag:"colo"`
+	ExcludeColos   string        `key:"xcolo" flag:"xcolo"`
+	WSPath         string        `key:"ws_path" flag:"ws-path"`
+	WSHost         string        `key:"ws_host" flag:"ws-host"`
+	MinSpeed       float64       `key:"min_speed" flag:"s"`
+	Conns          int           `key:"conns" flag:"conns"`
+	HTTPVersion    string        `key:"http_version" flag:"http-version"`
+	Upload         string        `key:"upload" flag:"upload"`
+	MinUpload      float64       `key:"min_upload" flag:"min-upload"`
+	SpeedSort      string        `key:"speed_sort" flag:"speed-sort"`
+	Score          string        `key:"score" flag:"score"`
+	DeepWorkers    int           `key:"deep_workers" flag:"dn"`
+	LinkMbps       float64       `key:"link_mbps" flag:"bw"`
+	OutCount       int           `key:"out_count" flag:"on"`
+	TestCount      int           `key:"test_count" flag:"tn"`
+	Refine         int           `key:"refine" flag:"refine"`
+	Seed           int64         `key:"seed" flag:"seed"`
+	Exclude        string        `key:"exclude" flag:"exclude"`
+	Blocklist      string        `key:"blocklist" flag:"blocklist"`
+	BlockTTL       time.Duration `key:"block_ttl" flag:"block-ttl"`
+	Checkpoint     string        `key:"checkpoint" flag:"checkpoint"`
+	Resume         string        `key:"resume" flag:"resume"`
+	AppendMode     bool          `key:"append" flag:"a"`
+	OutputFilePath string        `key:"append_file" flag:"p"`
+	Formats        string        `key:"format" flag:"format"`
+	Template       string        `key:"template" flag:"template"`
+	XrayTemplate   string        `key:"xray_template" flag:"xray-template"`
+	Interval       time.Duration `key:"interval" flag:"interval"`
+	Recheck        time.Duration `key:"recheck" flag:"recheck"`
+	Listen         string        `key:"listen" flag:"listen"`
+	History        string        `key:"history" flag:"history"`
+	HistoryRank    bool          `key:"history_rank" flag:"history-rank"`
+	HistoryDays    int           `key:"history_days" flag:"days"`
+
+	// 以下参数只能通过命令行指定
+	Command     string   // 子命令，空为单次扫描，serve 为常驻模式，history 为查询历史，plan 为只输出抽样计划
+	Args        []string // 子命令的位置参数，如 history 查询的 IP 或网段、plan 的输出文件
+	ConfigFile  string
+	Profile     string
+	PrintConfig bool
+	ShowVersion bool
+	Help        bool
 }
 
 func ParseConfig() Config {
@@ -25,18 +77,98 @@ func ParseConfig() Config {
 
 	// . 定义命令行参数
 	flag.StringVar(&c.Domain, "d", "speed.cloudflare.com/__down?bytes=100000000", "SNI Domain")
-	flag.StringVar(&c.IPFile, "f", "ip.txt", "包含 IP 段的文件路径")
+	flag.StringVar(&c.Scheme, "scheme", "https", "探测和测速使用的协议: https/http，http 用于 Cloudflare 的明文端口 (80/8080/8880/2052/2082/2086/2095)")
+	flag.StringVar(&c.IPFile, "f", "ip.txt", "包含 IP 段的文件路径，- 为标准输入")
+	flag.StringVar(&c.Ports, "ports", "", "探测端口，逗号分隔 (如 443,2053,8443)，每个 IP 与每个端口组合探测，为空时 https 探测 443、http 探测 80")
+	flag.BoolVar(&c.BestPort, "best-port", false, "同一 IP 的多个端口只保留扫描结果最好的一个")
+	flag.StringVar(&c.Resolver, "resolver", "", "解析 IP 文件中域名使用的 DNS 服务器 (如 1.1.1.1 或 1.1.1.1:53)，为空使用系统设置")
 	flag.StringVar(&c.OutFile, "o", "result", "输出文件路径加前缀 (不带后缀)")
 	flag.IntVar(&c.WorkerCount, "n", 100, "并发协程数")
 	flag.Int64Var(&c.LatencyLimit, "l", 200, "最低延时")
+	flag.StringVar(&c.Probe, "probe", "tls", "探测方式: "+strings.Join(scanner.ProberNames(), "/")+"，quic 测试 UDP 上的 QUIC 握手")
+	flag.DurationVar(&c.Timeout, "timeout", 2*time.Second, "单次探测超时")
+	flag.IntVar(&c.Rounds, "r", 1, "每个 IP 的握手轮数 (用于统计丢包率和抖动)")
+	flag.Float64Var(&c.MaxLoss, "loss", 100, "丢包率上限 (%)，超过则丢弃")
+	flag.StringVar(&c.SortBy, "sort", "latency", "扫描结果排序: latency/loss/jitter/p95")
+	flag.BoolVar(&c.Trace, "trace", false, "握手后请求 /cdn-cgi/trace 获取数据中心 (colo)")
+	flag.StringVar(&c.KeepColos, "colo", "", "只保留指定数据中心，逗号分隔 (如 HKG,NRT,LAX)")
+	flag.StringVar(&c.ExcludeColos, "xcolo", "", "排除指定数据中心，逗号分隔")
+	flag.StringVar(&c.WSPath, "ws-path", "", "扫描后对候选 IP 做 WebSocket 升级验证的路径 (如 /ws)，为空不验证")
+	flag.StringVar(&c.WSHost, "ws-host", "", "WebSocket 升级验证的 Host 和 SNI (Worker 域名)，为空时使用 -d 的域名")
 	flag.Float64Var(&c.MinSpeed, "s", 10, "最低下载")
+	flag.StringVar(&c.HTTPVersion, "http-version", "1.1", "下载测速使用的 HTTP 版本: 1.1/2/3，服务端未使用该版本时视为测速失败 (2 和 3 需要 https)")
+	flag.StringVar(&c.Upload, "upload", "", "上传测速地址 (如 speed.cloudflare.com/__up)，下载达标后 POST 随机数据测量上传速度，为空不测")
+	flag.Float64Var(&c.MinUpload, "min-upload", 0, "最低上传速度 (Mbps)，需要 -upload")
+	flag.StringVar(&c.SpeedSort, "speed-sort", "download", "测速结果排序: download/upload/total (上传 + 下载)/stable (下载速度 × 稳定性，波动大、卡顿多的 IP 靠后)")
+	flag.StringVar(&c.Score, "score", "", "综合得分，权重 (如 speed=0.6,latency=-0.3,loss=-10) 或表达式 (如 \"speed*0.6 - latency*0.3 - loss*10\")，指标: "+strings.Join(scanner.ScoreVarNames(), "/")+"，为空按 -sort / -speed-sort 排序")
+	flag.IntVar(&c.Conns, "conns", 1, "测速时对同一 IP 的并发连接数，大于 1 时额外测量聚合带宽")
+	flag.IntVar(&c.DeepWorkers, "dn", 1, "同时测速的 IP 数")
+	flag.Float64Var(&c.LinkMbps, "bw", 0, "本地链路带宽 (Mbps)，并发测速时总预期速率不超过它，0 为逐步增加并发直到总速率不再增长")
 	flag.IntVar(&c.OutCount, "on", 100, "最终结果数")
 	flag.IntVar(&c.TestCount, "tn", 500, "单个 IP 段期望测试的 IP 数量")
+	flag.IntVar(&c.Refine, "refine", 1, "自适应扫描轮数，大于 1 时后续轮次集中探测表现好的 /24 (IPv6 为 /48)")
+	flag.Int64Var(&c.Seed, "seed", 0, "抽样的随机种子，相同的种子和输入得到相同的抽样计划，0 为随机")
+	flag.StringVar(&c.Exclude, "exclude", "", "排除列表文件，每行一个 CIDR、IP 或范围 (a.b.c.d-a.b.c.e)")
+	flag.StringVar(&c.Blocklist, "blocklist", "", "自动屏蔽列表文件，测速未达标的 IP 在 -block-ttl 内不再抽样，为空不启用")
+	flag.DurationVar(&c.BlockTTL, "block-ttl", 24*time.Hour, "自动屏蔽的有效期")
+	flag.StringVar(&c.Checkpoint, "checkpoint", "", "扫描断点文件（如 scan.checkpoint.json），扫描中定期写入、完成后删除，为空不写")
+	flag.StringVar(&c.Resume, "resume", "", "从断点文件继续中断的扫描")
 	flag.BoolVar(&c.AppendMode, "a", false, "是否使用追加模式写入文件")
 	flag.StringVar(&c.OutputFilePath, "p", "./okresult.json", "输出到指定 JSON 文件（追加模式）")
+	flag.StringVar(&c.Formats, "format", "csv,json", "输出格式，逗号分隔: csv/json/v2ray/clash/singbox/xray")
+	flag.StringVar(&c.Template, "template", "", "clash/singbox 导出使用的节点模板文件 (YAML/JSON)")
+	flag.StringVar(&c.XrayTemplate, "xray-template", "", "xray 导出使用的 outbound 模板文件 (JSON)")
+	flag.DurationVar(&c.Interval, "interval", 6*time.Hour, "serve 模式下完整扫描的间隔")
+	flag.DurationVar(&c.Recheck, "recheck", 10*time.Minute, "serve 模式下两次完整扫描之间复查结果池的间隔，0 为不复查")
+	flag.StringVar(&c.Listen, "listen", "", "serve 模式下 HTTP API 的监听地址 (如 127.0.0.1:8080)，为空不启动")
+	flag.StringVar(&c.History, "history", "", "扫描历史数据库文件 (如 history.db)，为空不记录")
+	flag.BoolVar(&c.HistoryRank, "history-rank", false, "抽样和测速排序参考历史信誉 (需要 -history)")
+	flag.IntVar(&c.HistoryDays, "days", 7, "history 子命令显示最近多少天的趋势")
+	flag.StringVar(&c.ConfigFile, "config", "", "配置文件路径 (.yaml/.yml/.toml/.json)")
+	flag.StringVar(&c.Profile, "profile", "", "使用配置文件中的指定 profile")
+	flag.BoolVar(&c.PrintConfig, "print-config", false, "打印合并后的最终配置并退出")
 	flag.BoolVar(&c.ShowVersion, "v", false, "显示版本号")
 	flag.BoolVar(&c.Help, "h", false, "显示帮助信息")
 
-	flag.Parse()
+	// 第一个参数不是选项时视为子命令，如 ./cf-scanner serve -interval 6h
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		c.Command = args[0]
+		args = args[1:]
+		if c.Command == "daemon" {
+			c.Command = "serve"
+		}
+	}
+	// 位置参数可以出现在选项之前，如 ./cf-scanner history 104.16.0.1 -days 30
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			break
+		}
+		c.Args = append(c.Args, args[0])
+		args = args[1:]
+	}
+
+	// 优先级：命令行参数 > 环境变量 > 配置文件 profile > 配置文件 > 默认值
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	if err := loadLayers(&c, explicit); err != nil {
+		fmt.Fprintf(os.Stderr, "配置错误: %v\n", err)
+		os.Exit(2)
+	}
 	return c
 }
+
+// SplitList 将逗号分隔的参数拆分为大写列表，忽略空项
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.ToUpper(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+)
+
+// resolveTimeout 解析单个域名的超时
+const resolveTimeout = 5 * time.Second
+
+// 标准输入只能读一次，读到的内容缓存起来，
+// serve 模式的每次扫描和自适应扫描都使用同一份输入
+var (
+	stdinOnce    sync.Once
+	stdinContent []byte
+	stdinErr     error
+)
+
+// inputEntry IP 文件中的一项
+type inputEntry struct {
+	text    string       // 原始内容，用于提示
+	nets    []*net.IPNet // 需要抽样的网段（CIDR，或范围拆分成的网段）
+	ranged  bool         // nets 由 a-b 范围拆分而来，范围内每个地址都可用，抽样时不去掉各网段的首尾地址
+	targets []string     // 直接探测的地址（单个 IP、ip:port、域名解析结果），格式见 scanner.JoinAddr
+	fixed   bool         // 该项自带端口，不按 -ports 展开
+}
+
+// readSource 读取 IP 文件，路径为 "-" 时读取标准输入
+func readSource(path string) ([]byte, error) {
+	if path != "-" {
+		return os.ReadFile(path)
+	}
+	stdinOnce.Do(func() {
+		stdinContent, stdinErr = io.ReadAll(os.Stdin)
+	})
+	return stdinContent, stdinErr
+}
+
+// readInput 读取并解析 IP 文件，第二个返回值表示是否为 JSON 输入（[{"address": ...}]）
+// 每行可以是 CIDR、IP、a.b.c.d-a.b.c.e 范围、ip:port、[v6]:port、域名或 域名:端口，# 开头为注释
+// 无法解析的行会带上行号提示并跳过
+func readInput(c Config) ([]inputEntry, bool, error) {
+	content, err := readSource(c.IPFile)
+	if err != nil {
+		return nil, false, err
+	}
+	name := c.IPFile
+	if name == "-" {
+		name = "标准输入"
+	}
+	resolver := newResolver(c.Resolver)
+
+	var entries []inputEntry
+	add := func(where string, text string) {
+		entry, err := parseEntry(resolver, text)
+		if err != nil {
+			fmt.Printf("跳过 %s %s [%s]: %v\n", name, where, text, err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	// JSON 格式，解析失败时按普通文本处理
+	trimmed := strings.TrimSpace(string(content))
+	if strings.HasPrefix(trimmed, "[") {
+		var items []IPItem
+		if err := json.Unmarshal([]byte(trimmed), &items); err == nil {
+			for i, item := range items {
+				if item.Address != "" {
+					add(fmt.Sprintf("第 %d 项", i+1), item.Address)
+				}
+			}
+			return entries, true, nil
+		}
+	}
+
+	// 普通文本格式，行号从原始内容算起
+	sc := bufio.NewScanner(strings.NewReader(string(content)))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		add(fmt.Sprintf("第 %d 行", line), text)
+	}
+	return entries, false, sc.Err()
+}
+
+// parseEntry 解析一项输入
+func parseEntry(resolver *net.Resolver, text string) (inputEntry, error) {
+	entry := inputEntry{text: text}
+
+	// CIDR
+	if strings.Contains(text, "/") {
+		_, ipnet, err := net.ParseCIDR(text)
+		if err != nil {
+			return entry, fmt.Errorf("无效的网段")
+		}
+		entry.nets = []*net.IPNet{ipnet}
+		return entry, nil
+	}
+
+	// a.b.c.d-a.b.c.e 范围，两端都不是 IP 时可能是带 - 的域名
+	if from, to, ok := strings.Cut(text, "-"); ok && net.ParseIP(strings.TrimSpace(from)) != nil {
+		if net.ParseIP(strings.TrimSpace(to)) == nil {
+			return entry, fmt.Errorf("无效的 IP 范围")
+		}
+		nets, err := ParseIPRange(text)
+		if err != nil {
+			return entry, err
+		}
+		entry.nets = nets
+		entry.ranged = true
+		return entry, nil
+	}
+
+	// 单个 IP（包括不带端口的 IPv6）
+	if ip := net.ParseIP(text); ip != nil {
+		entry.targets = []string{ip.String()}
+		return entry, nil
+	}
+
+	// ip:port、[v6]:port、域名:端口，或不带端口的域名
+	host, port := text, scanner.DefaultPort
+	if h, p, err := net.SplitHostPort(text); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > 65535 {
+			return entry, fmt.Errorf("无效的端口 %q", p)
+		}
+		host, port = h, n
+		entry.fixed = true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		entry.targets = []string{scanner.JoinAddr(ip.String(), port)}
+		return entry, nil
+	}
+	if !validHostname(host) {
+		return entry, fmt.Errorf("无法识别的格式")
+	}
+
+	ips, err := resolveHost(resolver, host)
+	if err != nil {
+		return entry, err
+	}
+	for _, ip := range ips {
+		entry.targets = append(entry.targets, scanner.JoinAddr(ip, port))
+	}
+	return entry, nil
+}
+
+// validHostname 判断是否为合法的域名（字母、数字、- 组成的标签，以 . 分隔）
+func validHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// resolveHost 解析域名的全部 A/AAAA 记录
+func resolveHost(resolver *net.Resolver, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("解析域名失败: %v", err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("域名 %s 没有 A/AAAA 记录", host)
+	}
+
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP.String())
+	}
+	return ips, nil
+}
+
+// resolverAddr 将 -resolver 的取值补全为 IP:端口（默认 53），格式不对时返回空字符串
+func resolverAddr(server string) string {
+	if ip := net.ParseIP(strings.Trim(server, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), "53")
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || net.ParseIP(host) == nil {
+		return ""
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return ""
+	}
+	return server
+}
+
+// newResolver 返回解析域名使用的解析器，未指定 DNS 服务器时使用系统设置
+func newResolver(server string) *net.Resolver {
+	addr := resolverAddr(server)
+	if addr == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// ParsePorts 解析逗号分隔的端口列表（如 443,2053,8443），去掉重复的端口，空字符串返回 nil
+func ParsePorts(s string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		port, err := strconv.Atoi(item)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("无效的端口 %q", item)
+		}
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// withPorts 为每个 IP 生成 IP × 端口 的地址，ports 为空时原样返回
+func withPorts(ips []string, ports []int) []string {
+	if len(ports) == 0 {
+		return ips
+	}
+	addrs := make([]string, 0, len(ips)*len(ports))
+	for _, ip := range ips {
+		for _, port := range ports {
+			addrs = append(addrs, scanner.JoinAddr(ip, port))
+		}
+	}
+	return addrs
+}
+
+// splitBudget 按网段大小把 count 个抽样名额分给多个网段（如范围拆分出的网段），每个网段至少 1 个
+// whole 含义同 cidrRange
+func splitBudget(nets []*net.IPNet, whole bool, count int) []int {
+	if len(nets) == 1 {
+		return []int{count}
+	}
+
+	sizes := make([]*big.Int, len(nets))
+	total := new(big.Int)
+	for i, ipnet := range nets {
+		_, sizes[i] = cidrRange(ipnet, whole)
+		total.Add(total, sizes[i])
+	}
+
+	shares := make([]int, len(nets))
+	for i, size := range sizes {
+		share := new(big.Int).Mul(size, big.NewInt(int64(count)))
+		share.Div(share, total)
+		shares[i] = max(int(share.Int64()), 1)
+	}
+	return shares
+}
+
+// sampleNets 对一项输入的所有网段抽样，总数约为 count，whole 含义同 cidrRange
+func sampleNets(nets []*net.IPNet, whole bool, count int, opts SampleOptions) []string {
+	var sampled []string
+	for i, share := range splitBudget(nets, whole, count) {
+		sampled = append(sampled, pickSamples(nets[i], whole, share, opts)...)
+	}
+	return sampled
+}
@@ -1,41 +1,110 @@
 package utils
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
+	"math/big"
 	"math/rand"
 	"net"
-	"os"
 	"strings"
+	"time"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+)
+
+// 取样用的随机种子和随机数源（ParseIP 顺序执行，无需加锁）
+// 记录种子是为了在断点中保存，用同一种子可以重建同一份抽样计划
+var (
+	seed = time.Now().UnixNano()
+	rng  = rand.New(rand.NewSource(seed))
 )
 
+// SetSeed 重新设置取样的随机种子
+func SetSeed(s int64) {
+	seed = s
+	rng = rand.New(rand.NewSource(s))
+}
+
+// Seed 返回当前取样使用的随机种子
+func Seed() int64 {
+	return seed
+}
+
+// sampleTries 参考历史信誉或排除列表时，每个步长区间最多抽取的候选数
+const sampleTries = 3
+
+// jsonExpandLimit JSON 输入中的网段不超过该大小时全部展开，超过时只抽取这么多个 IP
+const jsonExpandLimit = 65536
+
 type IPItem struct {
 	Address string `json:"address"`
+	Colo    string `json:"colo,omitempty"`
+	Scheme  string `json:"scheme,omitempty"` // 扫描时使用的协议: https / http
+}
+
+// Reputation 历史信誉来源（见 history 包），用于抽样时偏向历史表现好的 IP 和网段
+type Reputation interface {
+	SubnetScore(ip string) float64                // IP 所在 /24（IPv6 为 /48）网段的信誉分 (0~1)
+	GoodIPs(ipnet *net.IPNet, limit int) []string // 网段内历史表现好的 IP，按信誉从高到低排列
 }
 
-func ParseIP(c Config) ([][]string, int) {
+// SampleOptions 抽样选项，零值为均匀随机抽样
+type SampleOptions struct {
+	Reputation Reputation // 不为 nil 时偏向历史表现好的 IP 和网段
+	Exclude    *IPSet     // 排除列表（-exclude 和自动屏蔽列表），其中的 IP 不会被抽到
+}
+
+// ParseIP 读取 IP 文件并对每个网段取样
+func ParseIP(c Config, opts SampleOptions) ([][]string, int) {
 	// 读取并解析 IP 段文件
-	cidrList, isJSONInput, err := ReadLines(c.IPFile)
+	entries, isJSONInput, err := readInput(c)
 	if err != nil {
 		fmt.Printf("无法读取 IP 文件: %v\n", err)
 		return nil, 0
 	}
+	return sampleEntries(c, entries, isJSONInput, opts)
+}
+
+// sampleEntries 对解析后的输入取样
+// 设置了 -ports 时每个 IP 按端口展开为多个地址，自带端口的项除外
+func sampleEntries(c Config, entries []inputEntry, isJSONInput bool, opts SampleOptions) ([][]string, int) {
+	ports := c.scanPorts()
 
-	// 每段分别取样
+	// 每段分别取样，直接探测的地址去重（如带 -ports 重新读取抽样计划时）
 	ipGroups := make([][]string, 1)
-	for _, cidr := range cidrList {
-		ips, _ := ParseCIDR(cidr)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		targets := entry.targets
+		if !entry.fixed {
+			targets = withPorts(targets, ports)
+		}
+		// 单个 IP、ip:port 和域名解析出的地址（如 plan 子命令输出的抽样计划）直接放入 groups[0]
+		for _, target := range targets {
+			if !seen[target] && !targetExcluded(opts.Exclude, target) {
+				seen[target] = true
+				ipGroups[0] = append(ipGroups[0], target)
+			}
+		}
+		if len(entry.nets) == 0 {
+			continue
+		}
+
 		if isJSONInput {
-			// json 文件全部 ip 读入groups[0]
-			ipGroups[0] = append(ipGroups[0], ips...)
-		} else {
-			// 每个 ip 段分别取样
-			groups := pickSamples(ips, c.TestCount)
-			fmt.Printf("IP 段 [%v] 随机抽样数为: %v\n", cidr, len(groups))
-			// 二维切片 ipGroups 的每个切片都是一个 ip 段取样的结果
-			ipGroups = append(ipGroups, groups)
+			// json 文件全部 ip 读入groups[0]，过大的网段按 jsonExpandLimit 抽样，不逐个展开
+			ips := sampleNets(entry.nets, entry.ranged, jsonExpandLimit, opts)
+			if len(ips) >= jsonExpandLimit {
+				ips = ips[:jsonExpandLimit]
+				fmt.Printf("IP 段 [%v] 超过 %d 个地址，随机抽样数为: %v\n", entry.text, jsonExpandLimit, len(ips))
+			}
+			ipGroups[0] = append(ipGroups[0], withPorts(ips, ports)...)
+			continue
 		}
+
+		// 每个 ip 段（或范围）分别取样，不展开整个网段
+		groups := sampleNets(entry.nets, entry.ranged, c.TestCount, opts)
+		fmt.Printf("IP 段 [%v] 随机抽样数为: %v\n", entry.text, len(groups))
+		groups = withPorts(groups, ports)
+		// 二维切片 ipGroups 的每个切片都是一个 ip 段取样的结果
+		ipGroups = append(ipGroups, groups)
 	}
 
 	// 预计算总数 (非常重要！)
@@ -46,114 +115,156 @@ func ParseIP(c Config) ([][]string, int) {
 		}
 	}
 
-	fmt.Printf("解析完成，总计 %d 个 IP，开始随机抽样扫描...\n", actualTaskCount)
+	if len(ports) > 1 {
+		fmt.Printf("解析完成，总计 %d 个地址（每个 IP 探测 %d 个端口），开始随机抽样扫描...\n", actualTaskCount, len(ports))
+	} else {
+		fmt.Printf("解析完成，总计 %d 个 IP，开始随机抽样扫描...\n", actualTaskCount)
+	}
 	return ipGroups, actualTaskCount
 }
 
-// readLines 从文件中读取所有行
-func ReadLines(path string) ([]string, bool, error) {
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return nil, false, err
-	}
-
-	trimmed := strings.TrimSpace(string(content))
-	isJSON := strings.HasPrefix(trimmed, "[")
-	var ips []string
-
-	// --- 逻辑判断：如果是 JSON 格式 ---
-	if strings.HasPrefix(trimmed, "[") {
-		var items []IPItem
-		if err := json.Unmarshal(content, &items); err == nil {
-			for _, item := range items {
-				if item.Address != "" {
-					ips = append(ips, item.Address)
-				}
-			}
-			return ips, isJSON, nil
-		}
-		// 如果 JSON 解析失败，则尝试按普通文本处理（回退机制）
-	}
+// targetExcluded 判断地址（可带端口）的 IP 是否在排除列表中
+func targetExcluded(exclude *IPSet, target string) bool {
+	ip, _, err := scanner.SplitAddr(target)
+	return err == nil && exclude.ContainsString(ip)
+}
 
-	// --- 逻辑判断：如果是普通文本格式 ---
-	scanner := bufio.NewScanner(strings.NewReader(trimmed))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" && !strings.HasPrefix(line, "#") {
-			ips = append(ips, line)
-		}
+// SampleCIDR 对网段、范围（a.b.c.d-a.b.c.e）或单个 IP 按步长随机取样，IPv4 与 IPv6 通用
+func SampleCIDR(cidr string, testCount int, opts SampleOptions) ([]string, error) {
+	nets, err := ParseIPRange(cidr)
+	if err != nil {
+		return nil, err
 	}
-	return ips, false, scanner.Err()
+	return sampleNets(nets, strings.Contains(cidr, "-"), testCount, opts), nil
 }
 
-// ParseCIDR 将网段（如 1.1.1.0/24）解析为具体的 IP 列表
+// maxExpand ParseCIDR 最多展开的地址数
+const maxExpand = 1 << 24
+
+// ParseCIDR 将网段（如 1.1.1.0/24）解析为具体的 IP 列表，不带 / 时按单个 IP 返回
+// 会展开整个网段（去掉网络地址和广播地址），超过 maxExpand 个地址时返回错误；扫描时请使用 SampleCIDR 按需取样
 func ParseCIDR(cidr string) ([]string, error) {
 	if !strings.Contains(cidr, "/") {
-		trialIP := net.ParseIP(cidr)
-		if trialIP != nil {
+		if net.ParseIP(cidr) != nil {
 			return []string{cidr}, nil
 		}
 		return nil, fmt.Errorf("无效格式")
 	}
 
-	ip, ipnet, err := net.ParseCIDR(cidr)
+	_, ipnet, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return nil, err
 	}
-
-	// 常规遍历 (适用于 IPv4 或极小的 IPv6 段)
-	var ips []string
-	for curr := ip.Mask(ipnet.Mask); ipnet.Contains(curr); inc(curr) {
-		// 注意：net.IP 是切片，必须复制一份，否则 append 的全是同一个值
-		temp := make(net.IP, len(curr))
-		copy(temp, curr)
-		ips = append(ips, temp.String())
+	first, total := cidrRange(ipnet, false)
+	if total.Cmp(big.NewInt(maxExpand)) > 0 {
+		return nil, fmt.Errorf("网段 %s 包含 %s 个地址，超过 %d 个无法展开", cidr, total, maxExpand)
 	}
 
-	if len(ips) <= 2 {
-		return ips, nil
+	ips := make([]string, 0, total.Int64())
+	for n := first; len(ips) < cap(ips); n.Add(n, big.NewInt(1)) {
+		ips = append(ips, intToIP(n, len(ipnet.IP)).String())
 	}
-	return ips[1 : len(ips)-1], nil
+	return ips, nil
 }
 
-// 通用的 IP 自增函数，支持 IPv4 和 IPv6
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
+// cidrRange 返回网段内可用地址的起始值与数量
+// 网段超过 2 个地址时去掉首尾地址（网络地址和广播地址）；
+// whole 为 true 时网段由 a-b 范围拆分而来，首尾地址是范围中的普通地址，全部保留
+func cidrRange(ipnet *net.IPNet, whole bool) (*big.Int, *big.Int) {
+	ones, bits := ipnet.Mask.Size()
+	first := new(big.Int).SetBytes(ipnet.IP.Mask(ipnet.Mask))
+	total := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+
+	if !whole && total.Cmp(big.NewInt(2)) > 0 {
+		first.Add(first, big.NewInt(1))
+		total.Sub(total, big.NewInt(2))
 	}
+	return first, total
+}
+
+// intToIP 将大整数还原为指定长度（4 或 16 字节）的 IP
+func intToIP(n *big.Int, size int) net.IP {
+	ip := make(net.IP, size)
+	n.FillBytes(ip)
+	return ip
 }
 
 // ip 段取样
-func pickSamples(ips []string, testCount int) []string {
+// 直接在网段的大整数区间上计算，每个步长区间内随机选一个偏移，不生成完整列表
+// 参考历史信誉时：先放入网段内历史表现好的 IP（最多占一半名额），
+// 其余每个步长区间随机取几个候选，选所在 /24 信誉最高的一个
+// 有排除列表时跳过其中的 IP，整段被排除时不抽样；whole 含义同 cidrRange
+func pickSamples(ipnet *net.IPNet, whole bool, testCount int, opts SampleOptions) []string {
+	if opts.Exclude.Covers(ipnet) {
+		return nil
+	}
+	rep := opts.Reputation
+
+	size := net.IPv6len
+	if ip4 := ipnet.IP.To4(); ip4 != nil && len(ipnet.Mask) == net.IPv4len {
+		size = net.IPv4len
+	}
+
+	// 参考历史信誉时，先放入历史表现好的 IP，剩余名额再按步长抽样
+	var sampled []string
+	seen := make(map[string]bool)
+	if rep != nil && testCount > 1 {
+		for _, ip := range rep.GoodIPs(ipnet, testCount/2) {
+			if opts.Exclude.ContainsString(ip) {
+				continue
+			}
+			sampled = append(sampled, ip)
+			seen[ip] = true
+		}
+		testCount -= len(sampled)
+	}
+
+	first, totalIPs := cidrRange(ipnet, whole)
 	// 引入随机步长
-	targetCount := testCount // 我们希望最终测试的 IP 数量
-	var currentStep int
+	targetCount := big.NewInt(int64(testCount)) // 我们希望最终测试的 IP 数量
+	currentStep := big.NewInt(1)
 
-	totalIPs := len(ips)
-	if totalIPs <= targetCount {
-		// 如果 IP 总数还没到希望最终测试的数量，没必要抽样，直接全测
-		currentStep = 1
-	} else {
+	if totalIPs.Cmp(targetCount) > 0 && testCount > 0 {
 		// 自动计算步长：总数 / 目标数
 		// 例如：500,000 / 200 = 2500 (步长)
-		currentStep = totalIPs / targetCount
+		currentStep.Div(totalIPs, targetCount)
 	}
+	// 如果 IP 总数还没到希望最终测试的数量，没必要抽样，直接全测 (步长为 1)
 
-	var sampled []string
+	tries := 1
+	if (rep != nil || opts.Exclude != nil) && currentStep.Cmp(big.NewInt(1)) > 0 {
+		tries = sampleTries
+	}
 
-	for i := 0; i < totalIPs; i += currentStep {
-		// 计算当前区间的结束位置
-		end := i + currentStep
-		if end > totalIPs {
-			end = totalIPs
+	for i := new(big.Int); i.Cmp(totalIPs) < 0; i.Add(i, currentStep) {
+		// 计算当前区间的长度，最后一段可能不足一个步长
+		width := new(big.Int).Sub(totalIPs, i)
+		if width.Cmp(currentStep) > 0 {
+			width.Set(currentStep)
 		}
 
-		// 在 [i, end) 区间内随机选一个索引
-		randomIndex := i + rand.Intn(end-i)
-		sampled = append(sampled, ips[randomIndex])
+		// 在 [i, i+width) 区间内随机选一个偏移，参考信誉时选多个取最好的
+		// 候选都在排除列表中时，本区间不取样
+		best, bestScore := "", -1.0
+		for t := 0; t < tries; t++ {
+			offset := new(big.Int).Rand(rng, width)
+			offset.Add(offset, i).Add(offset, first)
+			ip := intToIP(offset, size)
+			if opts.Exclude.Contains(ip) {
+				continue
+			}
+
+			if rep == nil {
+				best = ip.String()
+				break
+			}
+			if score := rep.SubnetScore(ip.String()); score > bestScore {
+				best, bestScore = ip.String(), score
+			}
+		}
+		if best != "" && !seen[best] {
+			sampled = append(sampled, best)
+		}
 	}
 
 	return sampled
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSampleCIDRRangeKeepsEveryAddress(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"1.1.1.1-1.1.1.10", 10},
+		{"1.1.1.0-1.1.1.255", 256},
+		{"2606:4700::1-2606:4700::8", 8},
+		// CIDR 去掉网络地址和广播地址
+		{"1.1.1.0/28", 14},
+	}
+	for _, tt := range tests {
+		ips, err := SampleCIDR(tt.input, 1000, SampleOptions{})
+		if err != nil {
+			t.Fatalf("SampleCIDR(%q): %v", tt.input, err)
+		}
+		if len(ips) != tt.want {
+			sort.Strings(ips)
+			t.Errorf("SampleCIDR(%q) 抽到 %d 个 IP，期望 %d: %s", tt.input, len(ips), tt.want, strings.Join(ips, " "))
+		}
+	}
+}
+
+func TestSplitBudgetRange(t *testing.T) {
+	// 1.1.1.1-1.1.1.10 拆分为 /32 /31 /30 /31 /32 五个网段
+	nets, err := ParseIPRange("1.1.1.1-1.1.1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, share := range splitBudget(nets, true, 10) {
+		total += share
+	}
+	if total != 10 {
+		t.Errorf("范围的抽样名额之和为 %d，期望 10", total)
+	}
+
+	_, ipnet, _ := net.ParseCIDR("1.1.1.4/30")
+	if _, n := cidrRange(ipnet, true); n.Int64() != 4 {
+		t.Errorf("范围中的 /30 可用地址数为 %v，期望 4", n)
+	}
+	if _, n := cidrRange(ipnet, false); n.Int64() != 2 {
+		t.Errorf("/30 网段可用地址数为 %v，期望 2", n)
+	}
+}
+
+func TestSampleEntriesJSONLargeNet(t *testing.T) {
+	var entries []inputEntry
+	for _, text := range []string{"2606:4700::/32", "1.1.1.0/30", "1.0.0.1-1.0.0.3"} {
+		entry, err := parseEntry(nil, text)
+		if err != nil {
+			t.Fatalf("parseEntry(%q): %v", text, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	groups, total := sampleEntries(Config{Scheme: "https", TestCount: 10}, entries, true, SampleOptions{})
+	// 大网段只抽取 jsonExpandLimit 个，小网段和范围全部展开
+	if want := jsonExpandLimit + 2 + 3; total != want || len(groups[0]) != want {
+		t.Fatalf("JSON 输入展开为 %d 个 IP，期望 %d", total, want)
+	}
+	for _, ip := range groups[0][:jsonExpandLimit] {
+		if !strings.HasPrefix(ip, "2606:4700:") {
+			t.Fatalf("抽样结果 %s 不在网段内", ip)
+		}
+	}
+	if got := strings.Join(groups[0][jsonExpandLimit:], " "); got != "1.1.1.1 1.1.1.2 1.0.0.1 1.0.0.2 1.0.0.3" {
+		t.Errorf("小网段展开为 %s", got)
+	}
+}
+
+func TestParseCIDR(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"1.1.1.1", []string{"1.1.1.1"}},
+		{"2606:4700::1", []string{"2606:4700::1"}},
+		// 去掉网络地址和广播地址，不超过 2 个地址时全部保留
+		{"1.1.1.0/29", []string{"1.1.1.1", "1.1.1.2", "1.1.1.3", "1.1.1.4", "1.1.1.5", "1.1.1.6"}},
+		{"1.1.1.5/31", []string{"1.1.1.4", "1.1.1.5"}},
+		{"1.1.1.5/32", []string{"1.1.1.5"}},
+		{"2606:4700::/126", []string{"2606:4700::1", "2606:4700::2"}},
+	}
+	for _, tt := range tests {
+		ips, err := ParseCIDR(tt.input)
+		if err != nil {
+			t.Errorf("ParseCIDR(%q): %v", tt.input, err)
+			continue
+		}
+		if strings.Join(ips, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("ParseCIDR(%q) = %v，期望 %v", tt.input, ips, tt.want)
+		}
+	}
+
+	if ips, err := ParseCIDR("10.0.0.0/16"); err != nil || len(ips) != 65534 || ips[65533] != "10.0.255.254" {
+		t.Errorf("ParseCIDR(10.0.0.0/16) 展开 %d 个地址，错误 %v", len(ips), err)
+	}
+	for _, input := range []string{"abc", "1.1.1.0/33", "2606:4700::/64"} {
+		if _, err := ParseCIDR(input); err == nil {
+			t.Errorf("ParseCIDR(%q) 应返回错误", input)
+		}
+	}
+}
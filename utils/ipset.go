@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+)
+
+// IPSet 基于二进制前缀树的 IP 集合
+// 查询只需沿地址的位向下走，耗时与条目数量无关，适合很大的排除列表
+type IPSet struct {
+	v4, v6 *trieNode
+	size   int
+}
+
+type trieNode struct {
+	child [2]*trieNode
+	end   bool // 到这里的前缀整段都在集合中
+}
+
+// NewIPSet 创建空集合
+func NewIPSet() *IPSet {
+	return &IPSet{v4: &trieNode{}, v6: &trieNode{}}
+}
+
+// root 返回地址族对应的树根和统一长度的地址
+func (s *IPSet) root(ip net.IP) (*trieNode, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return s.v4, ip4
+	}
+	return s.v6, ip.To16()
+}
+
+// bit 返回地址的第 i 位
+func bit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
+
+// Add 加入一个网段
+func (s *IPSet) Add(ipnet *net.IPNet) {
+	node, ip := s.root(ipnet.IP)
+	ones, _ := ipnet.Mask.Size()
+	for i := 0; i < ones; i++ {
+		if node.end {
+			return // 已被更大的网段覆盖
+		}
+		b := bit(ip, i)
+		if node.child[b] == nil {
+			node.child[b] = &trieNode{}
+		}
+		node = node.child[b]
+	}
+	if !node.end {
+		// 更小的网段已被覆盖，从计数中去掉
+		s.size -= node.count()
+		node.end = true
+		node.child = [2]*trieNode{}
+		s.size++
+	}
+}
+
+// count 返回以该节点为根的子树中的网段数
+func (n *trieNode) count() int {
+	if n == nil {
+		return 0
+	}
+	if n.end {
+		return 1
+	}
+	return n.child[0].count() + n.child[1].count()
+}
+
+// Contains 判断 IP 是否在集合中，nil 集合不包含任何 IP
+func (s *IPSet) Contains(ip net.IP) bool {
+	if s == nil || ip == nil {
+		return false
+	}
+	node, ip := s.root(ip)
+	for i := 0; node != nil; i++ {
+		if node.end {
+			return true
+		}
+		if i == len(ip)*8 {
+			return false
+		}
+		node = node.child[bit(ip, i)]
+	}
+	return false
+}
+
+// ContainsString 判断字符串形式的 IP 是否在集合中
+func (s *IPSet) ContainsString(ip string) bool {
+	return s.Contains(net.ParseIP(ip))
+}
+
+// Covers 判断整个网段是否都在集合中
+func (s *IPSet) Covers(ipnet *net.IPNet) bool {
+	if s == nil {
+		return false
+	}
+	node, ip := s.root(ipnet.IP)
+	ones, _ := ipnet.Mask.Size()
+	for i := 0; node != nil; i++ {
+		if node.end {
+			return true
+		}
+		if i == ones {
+			return false
+		}
+		node = node.child[bit(ip, i)]
+	}
+	return false
+}
+
+// Len 返回集合中的网段数（被覆盖的小网段不计）
+func (s *IPSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.size
+}
+
+// ParseIPRange 解析 CIDR、单个 IP 或 a.b.c.d-a.b.c.e 形式的范围，返回覆盖它的网段列表
+func ParseIPRange(s string) ([]*net.IPNet, error) {
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		start := net.ParseIP(strings.TrimSpace(from))
+		end := net.ParseIP(strings.TrimSpace(to))
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("无效的 IP 范围 %q", s)
+		}
+		return rangeToCIDRs(start, end)
+	}
+
+	if strings.Contains(s, "/") {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.IPNet{ipnet}, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("无效的 IP %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return []*net.IPNet{{IP: ip4, Mask: net.CIDRMask(32, 32)}}, nil
+	}
+	return []*net.IPNet{{IP: ip, Mask: net.CIDRMask(128, 128)}}, nil
+}
+
+// rangeToCIDRs 将 [start, end] 拆分为最少的对齐网段
+func rangeToCIDRs(start, end net.IP) ([]*net.IPNet, error) {
+	size, bits := net.IPv6len, 128
+	if start.To4() != nil && end.To4() != nil {
+		start, end = start.To4(), end.To4()
+		size, bits = net.IPv4len, 32
+	} else if start.To4() != nil || end.To4() != nil {
+		return nil, fmt.Errorf("IP 范围 %s-%s 的地址族不一致", start, end)
+	}
+
+	lo := new(big.Int).SetBytes(start)
+	hi := new(big.Int).SetBytes(end)
+	if lo.Cmp(hi) > 0 {
+		return nil, fmt.Errorf("IP 范围 %s-%s 的起始地址大于结束地址", start, end)
+	}
+
+	var nets []*net.IPNet
+	one := big.NewInt(1)
+	for lo.Cmp(hi) <= 0 {
+		// 从起始地址开始，找满足对齐且不超过结束地址的最大网段
+		host := 0
+		for host < bits && lo.Bit(host) == 0 {
+			last := new(big.Int).Lsh(one, uint(host+1))
+			last.Add(last, lo).Sub(last, one)
+			if last.Cmp(hi) > 0 {
+				break
+			}
+			host++
+		}
+		nets = append(nets, &net.IPNet{IP: intToIP(lo, size), Mask: net.CIDRMask(bits-host, bits)})
+		lo.Add(lo, new(big.Int).Lsh(one, uint(host)))
+	}
+	return nets, nil
+}
+
+// LoadIPSet 读取排除列表文件，每行一个 CIDR、IP 或范围，# 开头为注释
+// 无法解析的行返回带行号的错误
+func LoadIPSet(path string) (*IPSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	set := NewIPSet()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		nets, err := ParseIPRange(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s 第 %d 行: %v", path, line, err)
+		}
+		for _, ipnet := range nets {
+			set.Add(ipnet)
+		}
+	}
+	return set, scanner.Err()
+}
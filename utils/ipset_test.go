@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipnet
+}
+
+func TestIPSetLen(t *testing.T) {
+	tests := []struct {
+		adds []string
+		want int
+	}{
+		{[]string{"1.1.1.0/24", "1.1.2.0/24"}, 2},
+		// 重复加入不计数
+		{[]string{"1.1.1.0/24", "1.1.1.0/24"}, 1},
+		// 小网段已被覆盖
+		{[]string{"1.1.0.0/16", "1.1.1.0/24", "1.1.1.1/32"}, 1},
+		// 先加小网段再加覆盖它们的大网段
+		{[]string{"1.1.1.0/24", "1.1.2.0/24", "1.1.1.1/32", "1.1.0.0/16"}, 1},
+		{[]string{"1.1.1.0/24", "1.2.0.0/16", "1.1.0.0/16"}, 2},
+		{[]string{"1.1.1.0/24", "2606:4700::/32", "0.0.0.0/0"}, 2},
+	}
+	for _, tt := range tests {
+		s := NewIPSet()
+		for _, cidr := range tt.adds {
+			s.Add(mustCIDR(t, cidr))
+		}
+		if got := s.Len(); got != tt.want {
+			t.Errorf("依次加入 %v 后 Len() = %d，期望 %d", tt.adds, got, tt.want)
+		}
+	}
+
+	var nilSet *IPSet
+	if nilSet.Len() != 0 || nilSet.ContainsString("1.1.1.1") || nilSet.Covers(mustCIDR(t, "1.1.1.0/24")) {
+		t.Error("nil 集合应为空")
+	}
+}
+
+func TestIPSetContainsCovers(t *testing.T) {
+	s := NewIPSet()
+	for _, cidr := range []string{"1.1.1.0/24", "10.0.0.0/8", "2606:4700::/32"} {
+		s.Add(mustCIDR(t, cidr))
+	}
+
+	contains := map[string]bool{
+		"1.1.1.0":        true,
+		"1.1.1.255":      true,
+		"1.1.2.0":        false,
+		"10.255.255.255": true,
+		"11.0.0.0":       false,
+		"::ffff:1.1.1.1": true,
+		"2606:4700::1":   true,
+		"2606:4701::1":   false,
+		"not an ip":      false,
+	}
+	for ip, want := range contains {
+		if got := s.ContainsString(ip); got != want {
+			t.Errorf("ContainsString(%q) = %v，期望 %v", ip, got, want)
+		}
+	}
+
+	covers := map[string]bool{
+		"1.1.1.0/24":       true,
+		"1.1.1.128/25":     true,
+		"1.1.1.1/32":       true,
+		"1.1.0.0/16":       false, // 只覆盖了一部分
+		"10.1.0.0/16":      true,
+		"0.0.0.0/0":        false,
+		"2606:4700:1::/48": true,
+		"2606::/16":        false,
+	}
+	for cidr, want := range covers {
+		if got := s.Covers(mustCIDR(t, cidr)); got != want {
+			t.Errorf("Covers(%s) = %v，期望 %v", cidr, got, want)
+		}
+	}
+}
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"1.1.1.1", []string{"1.1.1.1/32"}},
+		{"2606:4700::1", []string{"2606:4700::1/128"}},
+		{"1.1.1.0/24", []string{"1.1.1.0/24"}},
+		{"1.1.1.7/24", []string{"1.1.1.0/24"}},
+		{"1.1.1.1-1.1.1.1", []string{"1.1.1.1/32"}},
+		{"1.1.1.0 - 1.1.1.255", []string{"1.1.1.0/24"}},
+		{"0.0.0.0-255.255.255.255", []string{"0.0.0.0/0"}},
+		{"::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"::/0"}},
+		// 未对齐的范围拆分为最少的网段
+		{"1.1.1.5-1.1.1.10", []string{"1.1.1.5/32", "1.1.1.6/31", "1.1.1.8/31", "1.1.1.10/32"}},
+		{"1.1.1.255-1.1.2.0", []string{"1.1.1.255/32", "1.1.2.0/32"}},
+		{"10.0.0.1-10.255.255.254", []string{
+			"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/30", "10.0.0.8/29", "10.0.0.16/28", "10.0.0.32/27",
+			"10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/24", "10.0.2.0/23", "10.0.4.0/22", "10.0.8.0/21",
+			"10.0.16.0/20", "10.0.32.0/19", "10.0.64.0/18", "10.0.128.0/17", "10.1.0.0/16", "10.2.0.0/15",
+			"10.4.0.0/14", "10.8.0.0/13", "10.16.0.0/12", "10.32.0.0/11", "10.64.0.0/10", "10.128.0.0/10",
+			"10.192.0.0/11", "10.224.0.0/12", "10.240.0.0/13", "10.248.0.0/14", "10.252.0.0/15", "10.254.0.0/16",
+			"10.255.0.0/17", "10.255.128.0/18", "10.255.192.0/19", "10.255.224.0/20", "10.255.240.0/21",
+			"10.255.248.0/22", "10.255.252.0/23", "10.255.254.0/24", "10.255.255.0/25", "10.255.255.128/26",
+			"10.255.255.192/27", "10.255.255.224/28", "10.255.255.240/29", "10.255.255.248/30",
+			"10.255.255.252/31", "10.255.255.254/32",
+		}},
+	}
+	for _, tt := range tests {
+		nets, err := ParseIPRange(tt.input)
+		if err != nil {
+			t.Errorf("ParseIPRange(%q): %v", tt.input, err)
+			continue
+		}
+		var got []string
+		for _, ipnet := range nets {
+			got = append(got, ipnet.String())
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("ParseIPRange(%q) = %v，期望 %v", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{
+		"",
+		"1.1.1",
+		"1.1.1.0/33",
+		"1.1.1.10-1.1.1.1",
+		"1.1.1.1-2606:4700::1",
+		"1.1.1.1-",
+	} {
+		if _, err := ParseIPRange(input); err == nil {
+			t.Errorf("ParseIPRange(%q) 应返回错误", input)
+		}
+	}
+}
+
+func TestLoadIPSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exclude.txt")
+	content := "# 注释\n\n1.1.1.1\n1.1.1.0-1.1.1.127\n1.1.1.0/24\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadIPSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 1 || !s.ContainsString("1.1.1.200") {
+		t.Errorf("Len() = %d，期望只剩 1.1.1.0/24", s.Len())
+	}
+
+	if err := os.WriteFile(path, []byte("1.1.1.1\nbad\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIPSet(path); err == nil || !strings.Contains(err.Error(), "第 2 行") {
+		t.Errorf("错误应包含行号，实际为 %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+)
+
+// refineBlock 自适应扫描中的一个网段（IPv4 为 /24，IPv6 为 /48，原网段更小时为原网段）
+// 设置了 -ports 时一个 IP 对应多个探测地址，成功率按地址统计，剩余数量按 IP 统计
+type refineBlock struct {
+	ipnet   *net.IPNet
+	whole   bool     // 属于 a-b 范围，首尾地址也可用（见 cidrRange）
+	size    *big.Int // 可用地址数
+	probed  int      // 已探测的 IP 数
+	jobs    int      // 已探测的地址数（IP × 端口）
+	success int      // 探测成功的地址数
+	latency int64    // 成功地址的平均延迟之和 (ms)
+}
+
+// score 网段的得分：成功率 × (全局最低平均延迟 / 本网段平均延迟)，全部失败时为 0
+func (b *refineBlock) score(bestLatency float64) float64 {
+	if b.success == 0 {
+		return 0
+	}
+	rate := float64(b.success) / float64(b.jobs)
+	avg := math.Max(float64(b.latency)/float64(b.success), 1)
+	return rate * bestLatency / avg
+}
+
+// remaining 网段内还没探测过的地址数（最多返回 limit）
+func (b *refineBlock) remaining(limit int) int {
+	left := new(big.Int).Sub(b.size, big.NewInt(int64(b.probed)))
+	if left.Sign() <= 0 {
+		return 0
+	}
+	if left.IsInt64() && left.Int64() < int64(limit) {
+		return int(left.Int64())
+	}
+	return limit
+}
+
+// blockOf 返回 IP 所属的细化网段
+func blockOf(ip net.IP, parent *net.IPNet) *net.IPNet {
+	prefix, bits := 24, 32
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else {
+		prefix, bits = 48, 128
+	}
+	if ones, _ := parent.Mask.Size(); ones >= prefix {
+		return parent
+	}
+	mask := net.CIDRMask(prefix, bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// RefineScan 多轮自适应扫描
+// 每个网段的 -tn 预算平均分给 c.Refine 轮：第一轮在各网段稀疏抽样，
+// 之后每轮把预算按得分分配给成功率高、延迟低的 /24（IPv6 为 /48），全部失败的网段不再探测
+// JSON 输入的是具体 IP，不需要细化，直接扫描一轮
+func RefineScan(ctx context.Context, c Config, sample SampleOptions, opts scanner.ScanOptions) []scanner.FinalResult {
+	// 从断点恢复时不需要读取 IP 文件
+	var entries []inputEntry
+	isJSONInput := false
+	if c.Resume == "" {
+		var err error
+		if entries, isJSONInput, err = readInput(c); err != nil {
+			fmt.Printf("无法读取 IP 文件: %v\n", err)
+			return nil
+		}
+	}
+	if c.Resume != "" || isJSONInput || c.Refine < 2 {
+		ipGroups, total, cp, err := scanPlan(c, entries, isJSONInput, sample)
+		if err != nil {
+			fmt.Printf("无法读取断点: %v\n", err)
+			return nil
+		}
+		opts.Checkpoint = cp
+		return bestPorts(c, scanner.RunScanPool(ctx, ipGroups, c.WorkerCount, opts, total))
+	}
+
+	// 收集每轮全部探测结果（包括失败的），用于统计网段得分
+	var (
+		mu          sync.Mutex
+		roundRes    []scanner.FinalResult
+		onResult    = opts.OnResult
+		seen        = make(map[string]bool)
+		parents     = make(map[string]*net.IPNet) // IP → 所属的原网段
+		ranged      = make(map[*net.IPNet]bool)   // 由 a-b 范围拆分出的原网段
+		blocks      = make(map[string]*refineBlock)
+		totalBudget int
+		ports       = c.scanPorts()
+	)
+	opts.OnResult = func(r scanner.FinalResult) {
+		mu.Lock()
+		roundRes = append(roundRes, r)
+		mu.Unlock()
+		if onResult != nil {
+			onResult(r)
+		}
+	}
+
+	// 第一轮：每个网段按 tn / 轮数 稀疏抽样，单个地址直接放入
+	// 范围拆分出的网段按大小分摊这一项的预算，之后各自作为原网段细化
+	var first []string
+	for _, entry := range entries {
+		targets := entry.targets
+		if !entry.fixed {
+			targets = withPorts(targets, ports)
+		}
+		for _, target := range targets {
+			if !seen[target] && !targetExcluded(sample.Exclude, target) {
+				seen[target] = true
+				first = append(first, target)
+				totalBudget++
+			}
+		}
+		if len(entry.nets) == 0 {
+			continue
+		}
+
+		size := new(big.Int)
+		for _, ipnet := range entry.nets {
+			_, n := cidrRange(ipnet, entry.ranged)
+			size.Add(size, n)
+			ranged[ipnet] = entry.ranged
+		}
+		budget := c.TestCount
+		if size.IsInt64() && size.Int64() < int64(budget) {
+			budget = int(size.Int64())
+		}
+		totalBudget += budget
+
+		for i, share := range splitBudget(entry.nets, entry.ranged, max(budget/c.Refine, 1)) {
+			for _, ip := range pickSamples(entry.nets[i], entry.ranged, share, sample) {
+				if seen[ip] {
+					continue
+				}
+				seen[ip] = true
+				for _, addr := range withPorts([]string{ip}, ports) {
+					parents[addr] = entry.nets[i]
+					first = append(first, addr)
+				}
+			}
+		}
+	}
+
+	var results []scanner.FinalResult
+	roundBudget := max(totalBudget/c.Refine, 1)
+	probes := 0
+	next := first
+
+	for round := 1; round <= c.Refine && len(next) > 0 && ctx.Err() == nil; round++ {
+		fmt.Printf("\n--- 自适应扫描第 %d/%d 轮：预算 %d，本轮探测 %d 个地址 ---\n", round, c.Refine, roundBudget, len(next))
+		roundRes = nil
+		found := scanner.RunScanPool(ctx, [][]string{next}, c.WorkerCount, opts, len(next))
+		results = append(results, found...)
+		probes += len(next)
+
+		// 按网段汇总本轮结果，同一 IP 的多个端口只计一次探测的 IP 数
+		counted := make(map[string]bool)
+		for _, r := range roundRes {
+			parent, ok := parents[r.Addr()]
+			if !ok {
+				continue
+			}
+			block := blockOf(net.ParseIP(r.IP), parent)
+			b, ok := blocks[block.String()]
+			if !ok {
+				whole := ranged[parent]
+				_, size := cidrRange(block, whole)
+				b = &refineBlock{ipnet: block, whole: whole, size: size}
+				blocks[block.String()] = b
+			}
+			b.jobs++
+			if !counted[r.IP] {
+				counted[r.IP] = true
+				b.probed++
+			}
+			if r.Success() {
+				b.success++
+				b.latency += r.RawLatency
+			}
+		}
+		if round == c.Refine || ctx.Err() != nil {
+			break
+		}
+
+		next = refineNext(blocks, roundBudget, seen, parents, ports, sample)
+	}
+
+	fmt.Printf("\n自适应扫描结束：共探测 %d 个地址（IP 预算 %d），找到 %d 个可用地址\n", probes, totalBudget, len(results))
+	scanner.SortResults(results, opts.SortBy)
+	opts.Scorer.Rank(results, opts.Reputation)
+	return bestPorts(c, results)
+}
+
+// bestPorts 设置了 -best-port 时，同一 IP 的多个端口只保留排序最靠前的一个
+func bestPorts(c Config, results []scanner.FinalResult) []scanner.FinalResult {
+	if !c.BestPort {
+		return results
+	}
+	kept := scanner.BestPortPerIP(results)
+	if len(kept) < len(results) {
+		fmt.Printf("每个 IP 只保留最好的端口：%d 个结果保留 %d 个\n", len(results), len(kept))
+	}
+	return kept
+}
+
+// scanPlan 返回单轮扫描的抽样计划和断点
+// 指定了 -resume 时从断点恢复（包括随机种子），否则对 entries 重新抽样，并在设置了 -checkpoint 时创建断点
+func scanPlan(c Config, entries []inputEntry, isJSONInput bool, sample SampleOptions) ([][]string, int, *scanner.Checkpoint, error) {
+	if c.Resume != "" {
+		cp, err := scanner.LoadCheckpoint(c.Resume)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		SetSeed(cp.Seed)
+		fmt.Printf("从断点 %s 恢复：计划 %d 个 IP，已探测 %d 个，已找到 %d 个可用 IP（种子 %d）\n",
+			c.Resume, cp.Total(), len(cp.Done), len(cp.Results), cp.Seed)
+		return cp.Plan, cp.Total(), cp, nil
+	}
+
+	ipGroups, total := sampleEntries(c, entries, isJSONInput, sample)
+	if c.Checkpoint == "" {
+		return ipGroups, total, nil, nil
+	}
+	return ipGroups, total, scanner.NewCheckpoint(c.Checkpoint, Seed(), ipGroups), nil
+}
+
+// CheckCheckpoint 检查新建的断点是否会覆盖上次中断留下的断点文件
+// 指定了 -resume 或未设置 -checkpoint 时不检查
+func CheckCheckpoint(c Config) error {
+	if c.Resume != "" || c.Checkpoint == "" {
+		return nil
+	}
+	if _, err := os.Stat(c.Checkpoint); err == nil {
+		return fmt.Errorf("断点文件 %s 已存在，可使用 -resume %s 继续上次的扫描；重新扫描请先删除该文件或用 -checkpoint 指定其他路径",
+			c.Checkpoint, c.Checkpoint)
+	}
+	return nil
+}
+
+// refineNext 按网段得分分配下一轮的预算，返回下一轮要探测的 IP
+func refineNext(blocks map[string]*refineBlock, budget int, seen map[string]bool, parents map[string]*net.IPNet, ports []int, sample SampleOptions) []string {
+	// 全局最低平均延迟，用于归一化延迟
+	bestLatency := math.MaxFloat64
+	var good []*refineBlock
+	skipped := 0
+	for _, b := range blocks {
+		if b.success == 0 {
+			skipped++
+			continue
+		}
+		good = append(good, b)
+		bestLatency = math.Min(bestLatency, math.Max(float64(b.latency)/float64(b.success), 1))
+	}
+
+	sort.Slice(good, func(i, j int) bool {
+		si, sj := good[i].score(bestLatency), good[j].score(bestLatency)
+		if si != sj {
+			return si > sj
+		}
+		return good[i].ipnet.String() < good[j].ipnet.String()
+	})
+	var total float64
+	for _, b := range good {
+		total += b.score(bestLatency)
+	}
+
+	// 按得分比例分配预算，得分高的网段优先，已经探测完的网段不再分配
+	var next []string
+	left := budget
+	used := 0
+	for _, b := range good {
+		if left <= 0 {
+			break
+		}
+		share := int(math.Ceil(float64(budget) * b.score(bestLatency) / total))
+		share = b.remaining(min(share, left))
+		if share == 0 {
+			continue
+		}
+
+		// 多取一些样本，打乱后去掉已经探测过的 IP
+		candidates := pickSamples(b.ipnet, b.whole, share+b.probed, sample)
+		rng.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+		var picked []string
+		for _, ip := range candidates {
+			if len(picked) >= share {
+				break
+			}
+			if !seen[ip] {
+				seen[ip] = true
+				picked = append(picked, ip)
+			}
+		}
+		for _, addr := range withPorts(picked, ports) {
+			parents[addr] = b.ipnet
+			next = append(next, addr)
+		}
+		left -= len(picked)
+		if len(picked) > 0 {
+			used++
+		}
+	}
+
+	fmt.Printf("网段统计：%d 个网段有可用 IP，跳过 %d 个全部失败的网段，下一轮分配给 %d 个网段\n", len(good), skipped, used)
+	return next
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+)
+
+// xrayBalancerTag 负载均衡器的标签
+const xrayBalancerTag = "cf-balancer"
+
+// LoadXrayOutbound 读取用户提供的 Xray / V2Ray outbound 模板（单个 outbound 的 JSON）
+// 支持 vless / vmess（settings.vnext）和 trojan（settings.servers）
+func LoadXrayOutbound(path string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var outbound map[string]interface{}
+	if err := json.Unmarshal(content, &outbound); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// 先试着替换一次地址，确认模板结构正确
+	if err := setXrayAddress(outbound, "0.0.0.0", 0); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return outbound, nil
+}
+
+// setXrayAddress 将 outbound 中的服务器地址替换为指定 IP
+// port 为非 443 端口时一并替换端口，否则保留模板中的端口
+func setXrayAddress(outbound map[string]interface{}, ip string, port int) error {
+	protocol, _ := outbound["protocol"].(string)
+	settings, ok := outbound["settings"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("outbound 缺少 settings")
+	}
+
+	var listKey string
+	switch protocol {
+	case "vless", "vmess":
+		listKey = "vnext"
+	case "trojan":
+		listKey = "servers"
+	default:
+		return fmt.Errorf("不支持的 outbound 协议 %q，可选 vless/vmess/trojan", protocol)
+	}
+
+	servers, ok := settings[listKey].([]interface{})
+	if !ok || len(servers) == 0 {
+		return fmt.Errorf("outbound 缺少 settings.%s", listKey)
+	}
+	server, ok := servers[0].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("settings.%s[0] 格式错误", listKey)
+	}
+	server["address"] = ip
+	if port != 0 && port != scanner.DefaultPort {
+		server["port"] = port
+	}
+	return nil
+}
+
+// cloneJSON 通过序列化深拷贝 JSON 对象
+func cloneJSON(v map[string]interface{}) (map[string]interface{}, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	err = json.Unmarshal(content, &out)
+	return out, err
+}
+
+// BuildXrayConfig 生成完整的 Xray 客户端配置：
+// 每个结果一个 outbound（按排名命名为 proxy-01、proxy-02 ...），
+// observatory 定期探测这些节点，balancer 以 leastPing 策略选择延迟最低的节点
+func BuildXrayConfig(template map[string]interface{}, data []scanner.FinalResult) (map[string]interface{}, error) {
+	// 标签统一宽度，避免 proxy-01 作为前缀匹配到 proxy-010
+	width := len(strconv.Itoa(len(data)))
+	if width < 2 {
+		width = 2
+	}
+
+	var outbounds []interface{}
+	var tags []string
+	for i, r := range data {
+		outbound, err := cloneJSON(template)
+		if err != nil {
+			return nil, err
+		}
+		if err := setXrayAddress(outbound, r.IP, r.Port); err != nil {
+			return nil, err
+		}
+
+		tag := fmt.Sprintf("proxy-%0*d", width, i+1)
+		outbound["tag"] = tag
+		outbounds = append(outbounds, outbound)
+		tags = append(tags, tag)
+	}
+	outbounds = append(outbounds, map[string]interface{}{"tag": "direct", "protocol": "freedom"})
+
+	return map[string]interface{}{
+		"log": map[string]interface{}{"loglevel": "warning"},
+		"inbounds": []interface{}{
+			map[string]interface{}{
+				"tag":      "socks-in",
+				"listen":   "127.0.0.1",
+				"port":     10808,
+				"protocol": "socks",
+				"settings": map[string]interface{}{"udp": true},
+			},
+			map[string]interface{}{
+				"tag":      "http-in",
+				"listen":   "127.0.0.1",
+				"port":     10809,
+				"protocol": "http",
+			},
+		},
+		"outbounds": outbounds,
+		"observatory": map[string]interface{}{
+			"subjectSelector":   tags,
+			"probeURL":          "https://www.gstatic.com/generate_204",
+			"probeInterval":     "1m",
+			"enableConcurrency": true,
+		},
+		"routing": map[string]interface{}{
+			"domainStrategy": "AsIs",
+			"balancers": []interface{}{
+				map[string]interface{}{
+					"tag":      xrayBalancerTag,
+					"selector": tags,
+					"strategy": map[string]interface{}{"type": "leastPing"},
+				},
+			},
+			"rules": []interface{}{
+				map[string]interface{}{
+					"type":        "field",
+					"network":     "tcp,udp",
+					"balancerTag": xrayBalancerTag,
+				},
+			},
+		},
+	}, nil
+}
+
+// SaveToXray 读取 outbound 模板并写入完整的 Xray 配置文件
+func SaveToXray(filename string, templatePath string, data []scanner.FinalResult) error {
+	template, err := LoadXrayOutbound(templatePath)
+	if err != nil {
+		return err
+	}
+
+	config, err := BuildXrayConfig(template, data)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, content)
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gzjjjfree/cf-scanner/scanner"
+)
+
+const xrayTemplate = `{
+	"protocol": "vless",
+	"settings": {"vnext": [{"address": "example.com", "port": 2053, "users": [{"id": "u", "encryption": "none"}]}]},
+	"streamSettings": {"network": "ws", "security": "tls", "wsSettings": {"path": "/ws"}}
+}`
+
+func TestSaveToXray(t *testing.T) {
+	dir := t.TempDir()
+	templatePath := writeFile(t, "outbound.json", xrayTemplate)
+
+	for _, count := range []int{3, 100} {
+		var data []scanner.FinalResult
+		for i := 0; i < count; i++ {
+			data = append(data, exportResults[i%len(exportResults)])
+		}
+		out := filepath.Join(dir, "result.xray.json")
+		if err := SaveToXray(out, templatePath, data); err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed struct {
+			Outbounds []struct {
+				Tag      string `json:"tag"`
+				Protocol string `json:"protocol"`
+				Settings struct {
+					Vnext []struct {
+						Address string `json:"address"`
+						Port    int    `json:"port"`
+					} `json:"vnext"`
+				} `json:"settings"`
+				StreamSettings struct {
+					Network string `json:"network"`
+				} `json:"streamSettings"`
+			} `json:"outbounds"`
+			Observatory struct {
+				SubjectSelector []string `json:"subjectSelector"`
+			} `json:"observatory"`
+			Routing struct {
+				Balancers []struct {
+					Tag      string   `json:"tag"`
+					Selector []string `json:"selector"`
+					Strategy struct {
+						Type string `json:"type"`
+					} `json:"strategy"`
+				} `json:"balancers"`
+				Rules []struct {
+					BalancerTag string `json:"balancerTag"`
+				} `json:"rules"`
+			} `json:"routing"`
+		}
+		if err := json.Unmarshal(content, &parsed); err != nil {
+			t.Fatalf("无法解析 Xray 输出: %v", err)
+		}
+
+		// 每个结果一个 outbound，最后是 direct
+		if len(parsed.Outbounds) != count+1 || parsed.Outbounds[count].Tag != "direct" {
+			t.Fatalf("%d 个结果生成了 %d 个 outbound", count, len(parsed.Outbounds))
+		}
+		width := 2
+		if count >= 100 {
+			width = 3
+		}
+		var tags []string
+		for i, o := range parsed.Outbounds[:count] {
+			want := fmt.Sprintf("proxy-%0*d", width, i+1)
+			r := data[i]
+			if o.Tag != want || o.Protocol != "vless" || o.StreamSettings.Network != "ws" {
+				t.Errorf("第 %d 个 outbound: tag %q，期望 %q", i+1, o.Tag, want)
+			}
+			if len(o.Settings.Vnext) != 1 || o.Settings.Vnext[0].Address != r.IP || o.Settings.Vnext[0].Port != exportPorts[i%len(exportPorts)] {
+				t.Errorf("第 %d 个 outbound 地址: %+v", i+1, o.Settings.Vnext)
+			}
+			tags = append(tags, o.Tag)
+		}
+
+		// balancer 和 observatory 选择的正好是所有节点
+		if len(parsed.Routing.Balancers) != 1 {
+			t.Fatalf("balancers = %+v", parsed.Routing.Balancers)
+		}
+		balancer := parsed.Routing.Balancers[0]
+		if balancer.Strategy.Type != "leastPing" || len(parsed.Routing.Rules) != 1 || parsed.Routing.Rules[0].BalancerTag != balancer.Tag {
+			t.Errorf("routing = %+v", parsed.Routing)
+		}
+		if strings.Join(balancer.Selector, ",") != strings.Join(tags, ",") {
+			t.Errorf("balancer selector = %v，期望 %v", balancer.Selector, tags)
+		}
+		if strings.Join(parsed.Observatory.SubjectSelector, ",") != strings.Join(tags, ",") {
+			t.Errorf("observatory subjectSelector = %v，期望 %v", parsed.Observatory.SubjectSelector, tags)
+		}
+		// selector 按前缀匹配，任何一个标签都不能是另一个标签的前缀
+		for _, a := range tags {
+			for _, b := range tags {
+				if a != b && strings.HasPrefix(b, a) {
+					t.Errorf("标签 %s 是 %s 的前缀", a, b)
+				}
+			}
+		}
+	}
+}
+
+func TestLoadXrayOutboundErrors(t *testing.T) {
+	tests := map[string]string{
+		"shadowsocks.json": `{"protocol": "shadowsocks", "settings": {"servers": [{}]}}`,
+		"nosettings.json":  `{"protocol": "vless"}`,
+		"novnext.json":     `{"protocol": "vmess", "settings": {"vnext": []}}`,
+		"trojan.json":      `{"protocol": "trojan", "settings": {"vnext": [{}]}}`,
+		"bad.json":         `{`,
+	}
+	for name, content := range tests {
+		if _, err := LoadXrayOutbound(writeFile(t, name, content)); err == nil {
+			t.Errorf("%s 应返回错误", name)
+		}
+	}
+}